@@ -12,11 +12,22 @@ type blockReader struct {
 	length    int64
 	pos       int64
 	cblock    []byte
+	loaded    bool
 }
 
+// newBlockReader does not touch the cursor until the first Read or Seek.
 func newBlockReader(c Cursor, blockSize, length int64) *blockReader {
-	_, fblock := c.First()
-	return &blockReader{c: c, blockSize: blockSize, length: length, cblock: fblock}
+	return &blockReader{c: c, blockSize: blockSize, length: length}
+}
+
+// load positions the cursor at the block containing the current position.
+func (br *blockReader) load() {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(br.pos/br.blockSize))
+	_, br.cblock = br.c.Seek(b)
+	rem := br.pos % br.blockSize
+	br.cblock = br.cblock[rem:]
+	br.loaded = true
 }
 
 func (br *blockReader) Seek(offset int64, whence int) (int64, error) {
@@ -35,11 +46,7 @@ func (br *blockReader) Seek(offset int64, whence int) (int64, error) {
 		return br.pos, fmt.Errorf("new position is beyond contents of file")
 	}
 	br.pos = newPos
-	b := make([]byte, 8)
-	binary.LittleEndian.PutUint64(b, uint64(newPos/br.blockSize))
-	_, br.cblock = br.c.Seek(b)
-	rem := newPos % br.blockSize
-	br.cblock = br.cblock[rem:]
+	br.load()
 	return newPos, nil
 }
 
@@ -48,6 +55,9 @@ func (br *blockReader) Read(p []byte) (int, error) {
 	if br.pos == br.length {
 		return 0, io.EOF
 	}
+	if !br.loaded {
+		br.load()
+	}
 	plen := len(p)
 	clen := len(br.cblock)
 	if plen < clen {
@@ -62,7 +72,13 @@ func (br *blockReader) Read(p []byte) (int, error) {
 		br.pos += int64(clen)
 		p = p[clen:]
 		read += clen
-		_, br.cblock = br.c.Next()
+		// block keys are little endian, so the next key in the cursor
+		// is not always the next block
+		if br.pos == br.length {
+			br.cblock = nil
+			break
+		}
+		br.load()
 		clen = len(br.cblock)
 	}
 	if br.pos == br.length {
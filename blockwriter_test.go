@@ -30,6 +30,9 @@ func (t *bwMockTx) Put(key, val []byte) error {
 func (t *bwMockTx) Get([]byte) []byte {
 	panic("not implemented")
 }
+func (t *bwMockTx) Delete([]byte) error {
+	panic("not implemented")
+}
 func (t *bwMockTx) CreateBucket([]byte) (Bucket, error) {
 	panic("not implemented")
 }
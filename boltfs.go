@@ -1,14 +1,18 @@
 package boltfs
 
 import (
+	"bytes"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
 	"gopkg.in/vmihailenco/msgpack.v2"
 	"io"
+	iofs "io/fs"
 	"net/http"
 	"os"
-	"path"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -17,18 +21,155 @@ const inodeIndexKey = "inode_index"
 const versionKey = "boltfs_version"
 const fsKey = "fs"
 const inodesKey = "inodes"
+const metaKey = "meta"
+const uploadsKey = "uploads"
 const Version = 1
 
-//TODO: FileSystem and File change to interfaces
-
+// FileSystem is a tree of files and directories with extended attributes.
+// It is implemented by the FileSystems of a store as well as by MountFS and
+// Overlay. The other features of a store are grouped into the optional
+// interfaces below, which can be checked for with a type assertion.
 type FileSystem interface {
 	http.FileSystem
 	Create(string) (io.WriteCloser, error)
+	CreateWithOptions(string, *CreateOptions) (io.WriteCloser, error)
+	Stat(string) (os.FileInfo, error)
+	Mkdir(string) error
+	Remove(string) error
+	RemoveAll(string) error
+	Rename(oldName, newName string) error
+
+	SetXattr(name, key string, value []byte) error
+	GetXattr(name, key string) ([]byte, error)
+	ListXattr(name string) ([]string, error)
+	RemoveXattr(name, key string) error
+}
+
+// WalkFS lists directories in pages and walks trees.
+type WalkFS interface {
+	FileSystem
+	ReadDirFrom(dir, after string, n int) ([]os.FileInfo, error)
+	Walk(root string, fn filepath.WalkFunc) error
+	Glob(pattern string) ([]string, error)
+}
+
+// QuotaFS reports the space taken by directory trees and limits it.
+type QuotaFS interface {
+	FileSystem
+	Usage(name string) (*Usage, error)
+	StatFS() (*Usage, error)
+	SetQuota(name string, q Quota) error
+	GetQuota(name string) (Quota, error)
+}
+
+// VersionFS keeps the earlier versions of files.
+type VersionFS interface {
+	FileSystem
+	Versions(name string) ([]FileVersion, error)
+	OpenVersion(name, id string) (http.File, error)
+	Restore(name, id string) error
+}
+
+// TrashFS keeps removed files until they are purged.
+type TrashFS interface {
+	FileSystem
+	ListTrash() ([]TrashEntry, error)
+	Undelete(id string) error
+	PurgeTrash(olderThan time.Duration) (int, error)
+}
+
+// SnapshotFS saves the state of the whole FileSystem under a name.
+type SnapshotFS interface {
+	FileSystem
+	Snapshot(name string) error
+	OpenSnapshot(name string) (http.FileSystem, error)
+	ListSnapshots() ([]SnapshotInfo, error)
+	DeleteSnapshot(name string) error
+}
+
+// UploadFS writes files over several requests or process restarts.
+type UploadFS interface {
+	FileSystem
+	CreateUpload(name string, length int64, opts *CreateOptions) (string, error)
+	StatUpload(id string) (*UploadInfo, error)
+	WriteUpload(id string, offset int64, r io.Reader) (int64, error)
+	RemoveUpload(id string) error
+	CreateSession(name string, opts *CreateOptions) (string, error)
+	ResumeSession(id string) (*Session, error)
+}
+
+// ArchiveFS exports and imports trees as tar and zip archives.
+type ArchiveFS interface {
+	FileSystem
+	ExportTar(w io.Writer, root string) error
+	ImportTar(r io.Reader, root string, opts *ImportOptions) error
+	ExportZip(w io.Writer, root string) error
+}
+
+// Store is a FileSystem stored in bolt, as returned by NewFileSystem and
+// Sub. It implements every optional interface, and can only be
+// implemented by this package.
+type Store interface {
+	WalkFS
+	QuotaFS
+	VersionFS
+	TrashFS
+	SnapshotFS
+	UploadFS
+	ArchiveFS
+	Sub(dir string) (Store, error)
+	Staging() (FileSystem, error)
+	Fsck(repair bool) (*FsckReport, error)
+
+	// whiteouts are only used by Overlay
+	whiteout(name string) error
+	isWhiteout(name string) (bool, error)
+}
+
+// CreateOptions control how a new file is stored.
+type CreateOptions struct {
+	// ContentType and ContentEncoding are served as the Content-Type and
+	// Content-Encoding headers by Handler.
+	ContentType     string
+	ContentEncoding string
+
+	// Xattrs are stored with the file when it is closed.
+	Xattrs map[string][]byte
+
+	// ModTime is the modification time of the file. The time the file is
+	// closed is used if it is zero.
+	ModTime time.Time
+}
+
+// Aborter is implemented by the writers returned from Create. Abort discards
+// the data written so far, leaving any existing file in place.
+type Aborter interface {
+	Abort() error
+}
+
+// XattrSetter is implemented by the writers returned from Create. SetXattr
+// adds an xattr that is stored along with the file when it is closed, for
+// values such as checksums that are only known once the data is written.
+type XattrSetter interface {
+	SetXattr(key string, value []byte) error
+}
+
+// FileMeta is returned by the Sys method of file info from boltfs.
+type FileMeta struct {
+	ContentType     string
+	ContentEncoding string
+	// ETag is a strong entity tag for the file contents, including quotes.
+	ETag   string
+	Xattrs map[string][]byte
 }
 
 type boltFs struct {
 	db   DB
 	path BucketPath
+	// root is the bucket that names are resolved in, the fs bucket unless
+	// the FileSystem was returned by Sub.
+	root BucketPath
+	opts Options
 }
 
 type fileStat struct {
@@ -38,6 +179,12 @@ type fileStat struct {
 	BlockSize int64
 	Inode     BucketPath
 	MTime     time.Time
+
+	ContentType     string
+	ContentEncoding string
+	Hash            []byte
+
+	xattrs map[string][]byte
 }
 
 type readableFile struct {
@@ -45,17 +192,31 @@ type readableFile struct {
 	tx   Transaction
 	bk   Bucket
 	stat fileStat
-	c    Cursor
 	br   *blockReader
+
+	// c and k, v are the cursor and the next entry of a directory
+	c    Cursor
+	k, v []byte
+	// opts are the options of the FileSystem, to find names in a directory
+	opts Options
+}
+
+func NewFileSystem(db DB, path BucketPath) (Store, error) {
+	return NewFileSystemWithOptions(db, path, nil)
 }
 
-func NewFileSystem(db DB, path BucketPath) (FileSystem, error) {
+// NewFileSystemWithOptions is like NewFileSystem, but creates the
+// FileSystem with opts. Opening an existing FileSystem fails if opts differ
+// from the saved options, while NewFileSystem uses the saved options.
+func NewFileSystemWithOptions(db DB, path BucketPath, opts *Options) (Store, error) {
+	var saved Options
 	err := db.Update(func(tx Transaction) error {
 		bk, err := path.MkFrom(tx)
 		if err != nil {
 			return err
 		}
 		data := bk.Get([]byte(versionKey))
+		created := len(data) == 0
 		if len(data) == 8 {
 			v := binary.LittleEndian.Uint64(data)
 			if v != Version {
@@ -71,8 +232,12 @@ func NewFileSystem(db DB, path BucketPath) (FileSystem, error) {
 				return err
 			}
 		}
+		saved, err = loadOptions(bk, opts, created)
+		if err != nil {
+			return err
+		}
 
-		_, err = bk.CreateBucketIfNotExists([]byte(fsKey))
+		fsBk, err := bk.CreateBucketIfNotExists([]byte(fsKey))
 		if err != nil {
 			return err
 		}
@@ -80,6 +245,33 @@ func NewFileSystem(db DB, path BucketPath) (FileSystem, error) {
 		if err != nil {
 			return err
 		}
+		_, err = bk.CreateBucketIfNotExists([]byte(metaKey))
+		if err != nil {
+			return err
+		}
+		_, err = bk.CreateBucketIfNotExists([]byte(uploadsKey))
+		if err != nil {
+			return err
+		}
+		_, err = bk.CreateBucketIfNotExists([]byte(trashKey))
+		if err != nil {
+			return err
+		}
+		_, err = bk.CreateBucketIfNotExists([]byte(snapshotsKey))
+		if err != nil {
+			return err
+		}
+		_, err = bk.CreateBucketIfNotExists([]byte(stagingKey))
+		if err != nil {
+			return err
+		}
+		if saved.Counters && fsBk.Get([]byte(usageKey)) == nil {
+			fs := &boltFs{path: path}
+			_, err = fs.countUsage(tx, path.Join([]byte(fsKey)))
+			if err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 
@@ -87,32 +279,14 @@ func NewFileSystem(db DB, path BucketPath) (FileSystem, error) {
 		return nil, err
 	}
 
-	return &boltFs{db: db, path: path}, nil
+	return &boltFs{db: db, path: path, root: path.Join([]byte(fsKey)), opts: saved}, nil
 }
 func (fs *boltFs) nextInode() (BucketPath, error) {
 	var bpath BucketPath
-	val := make([]byte, 8)
 	err := fs.db.Update(func(tx Transaction) error {
-		bk := fs.path.BucketFrom(tx)
-		b := bk.Get([]byte(inodeIndexKey))
-		var index uint64
-		if len(b) == 8 {
-			copy(val, b)
-			index = binary.LittleEndian.Uint64(b)
-		}
-		index++
-		buf := make([]byte, 8)
-		binary.LittleEndian.PutUint64(buf, index)
-		err := bk.Put([]byte(inodeIndexKey), buf)
-		if err != nil {
-			return err
-		}
-		bpath = fs.path.Join([]byte(inodesKey), val)
-		_, err = bpath.CreateFrom(tx)
-		if err != nil {
-			return err
-		}
-		return nil
+		var err error
+		bpath, err = fs.nextInodeTx(tx)
+		return err
 	})
 	if err != nil {
 		return nil, err
@@ -120,91 +294,232 @@ func (fs *boltFs) nextInode() (BucketPath, error) {
 	return bpath, nil
 }
 
-func (fs *boltFs) fsPath(name string) BucketPath {
-	parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
-	p := make(BucketPath, len(fs.path), len(fs.path)+len(parts)+1)
-	copy(p, fs.path)
-	p = append(p, []byte(fsKey))
-	for _, part := range parts {
-		if part == "" {
-			continue
-		}
-		p = append(p, []byte(part))
+// nextInodeTx allocates and creates an inode bucket within tx.
+func (fs *boltFs) nextInodeTx(tx Transaction) (BucketPath, error) {
+	val := make([]byte, 8)
+	bk := fs.path.BucketFrom(tx)
+	b := bk.Get([]byte(inodeIndexKey))
+	var index uint64
+	if len(b) == 8 {
+		copy(val, b)
+		index = binary.LittleEndian.Uint64(b)
 	}
-	return p
+	index++
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, index)
+	err := bk.Put([]byte(inodeIndexKey), buf)
+	if err != nil {
+		return nil, err
+	}
+	bpath := fs.path.Join([]byte(inodesKey), val)
+	_, err = bpath.CreateFrom(tx)
+	if err != nil {
+		return nil, err
+	}
+	return bpath, nil
+}
+
+// reservedKey reports if k is reserved for entries that are not files, such
+// as whiteouts. Reserved keys start with a NUL byte and are never listed.
+func reservedKey(k []byte) bool {
+	return len(k) > 0 && k[0] == 0
+}
+
+// fsPath returns the stat path for name after checking it with splitPath.
+// Names cannot contain NUL bytes, so reserved keys are never reached.
+func (fs *boltFs) fsPath(name string) (BucketPath, error) {
+	p, _, err := fs.fsNames(name)
+	return p, err
+}
+
+func (fs *boltFs) isRoot(p BucketPath) bool {
+	return len(p) == len(fs.root)
+}
+
+// rootKey names the namespace bucket root, for data kept apart from the
+// namespace for every FileSystem. Keys of the namespace cannot contain a
+// slash, so joining them is unambiguous.
+func rootKey(path, root BucketPath) []byte {
+	return bytes.Join(root[len(path):], []byte("/"))
+}
+
+// isSub reports if fs was returned by Sub.
+func (fs *boltFs) isSub() bool {
+	return len(fs.root) > len(fs.path)+1
 }
 
 func (fs *boltFs) Create(name string) (io.WriteCloser, error) {
-	_, file := path.Split(name)
-	if file == "" {
+	return fs.CreateWithOptions(name, nil)
+}
+
+func (fs *boltFs) CreateWithOptions(name string, opts *CreateOptions) (io.WriteCloser, error) {
+	statPath, names, err := fs.fsNames(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "create", Path: name, Err: err}
+	}
+	if strings.HasSuffix(name, "/") || fs.isRoot(statPath) {
 		return nil, fmt.Errorf("open %s: is a directory", name)
 	}
-	statPath := fs.fsPath(name)
+	err = fs.checkCreate("create", name, statPath, names[len(names)-1])
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &CreateOptions{}
+	}
+	xattrs, err := copyXattrs(name, opts.Xattrs)
+	if err != nil {
+		return nil, err
+	}
 	inodePath, err := fs.nextInode()
 	if err != nil {
 		return nil, err
 	}
 
-	return newWritableFile(fs.db.Batch, blockSize, inodePath, statPath), nil
+	wf := newWritableFile(fs.db.Batch, blockSize, inodePath, statPath)
+	wf.names = names
+	wf.contentType = opts.ContentType
+	wf.contentEncoding = opts.ContentEncoding
+	wf.xattrs = xattrs
+	wf.modTime = opts.ModTime
+	wf.keep = fs.opts.Retention
+	wf.checkSpace = func(length int64) error {
+		return fs.checkSpace("write", name, statPath, length)
+	}
+	return wf, nil
 }
 
-func (fs *boltFs) Open(name string) (http.File, error) {
-	var dir, file string
-	if name == "/" {
-		dir = ""
-		file = ""
-	} else if strings.HasSuffix(name, "/") {
-		dir = strings.TrimSuffix(name, "/")
-		file = ""
-	} else {
-		dir, file = path.Split(name)
+// copyXattrs copies the create-time xattrs for name, so later changes by
+// the caller do not affect the stored file.
+func copyXattrs(name string, src map[string][]byte) (map[string][]byte, error) {
+	xattrs := make(map[string][]byte, len(src))
+	for key, val := range src {
+		if key == "" {
+			return nil, fmt.Errorf("create %s: empty xattr name", name)
+		}
+		xattrs[key] = append([]byte{}, val...)
 	}
+	return xattrs, nil
+}
 
-	tx, err := fs.db.Begin(false)
+// lookup finds the stat for name, returning the bucket it is stored in.
+// Directories are returned with a nil parent bucket.
+func (fs *boltFs) lookup(tx Transaction, name string) (Bucket, fileStat, error) {
+	var stat fileStat
+	p, err := fs.fsPath(name)
 	if err != nil {
-		return nil, err
+		return nil, stat, err
+	}
+	if fs.isRoot(p) {
+		stat.Dir = true
+		return nil, stat, nil
 	}
-	dirPath := fs.fsPath(dir)
-	bk := dirPath.BucketFrom(tx)
+	bk := BucketPath(p[:len(p)-1]).BucketFrom(tx)
 	if bk == nil {
-		tx.Commit()
-		return nil, fmt.Errorf("file not found")
+		return nil, stat, os.ErrNotExist
+	}
+	key := p[len(p)-1]
+	if dbk := bk.Bucket(key); dbk != nil {
+		stat.Dir = true
+		stat.Filename = dirName(dbk, key)
+		return nil, stat, nil
+	}
+	data := bk.Get(key)
+	if len(data) == 0 {
+		return nil, stat, os.ErrNotExist
+	}
+	err = msgpack.Unmarshal(data, &stat)
+	if err != nil {
+		return nil, stat, err
+	}
+	return bk, stat, nil
+}
+
+func (fs *boltFs) Stat(name string) (os.FileInfo, error) {
+	var stat fileStat
+	err := fs.db.View(func(tx Transaction) error {
+		var err error
+		_, stat, err = fs.lookup(tx, name)
+		if err != nil {
+			return &os.PathError{Op: "stat", Path: name, Err: err}
+		}
+		if stat.Dir && stat.Filename == "" {
+			stat.Filename = "/"
+		}
+		stat.xattrs = readXattrs(tx, stat.Inode)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stat, nil
+}
+
+// deleteInode removes the blocks and metadata belonging to an inode.
+func deleteInode(tx Bucketer, inode BucketPath) {
+	if len(inode) == 0 || dropRef(tx, inode) {
+		return
+	}
+	inode.DeleteFrom(tx)
+	metaPath(inode).DeleteFrom(tx)
+}
+
+func (fs *boltFs) Open(name string) (http.File, error) {
+	p, err := fs.fsPath(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	tx, err := fs.db.Begin(false)
+	if err != nil {
+		return nil, err
 	}
 
 	var rf readableFile
 	rf.tx = tx
-	if file == "" {
-		rf.bk = bk
+	rf.opts = fs.opts
+	if fs.isRoot(p) {
+		rf.bk = p.BucketFrom(tx)
 		rf.stat.Dir = true
-		rf.stat.Filename = path.Base(dir)
+		rf.stat.Filename = "/"
 		return &rf, nil
 	}
+	bk := BucketPath(p[:len(p)-1]).BucketFrom(tx)
+	if bk == nil {
+		tx.Rollback()
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
 
-	dbk := bk.Bucket([]byte(file))
+	key := p[len(p)-1]
+	dbk := bk.Bucket(key)
 	if dbk != nil {
 		rf.bk = dbk
 		rf.stat.Dir = true
-		rf.stat.Filename = file
+		rf.stat.Filename = dirName(dbk, key)
 		return &rf, nil
 	}
 
-	data := bk.Get([]byte(file))
-	if len(data) == 0 || data == nil {
-		tx.Commit()
-		return nil, fmt.Errorf("file not found")
+	data := bk.Get(key)
+	if len(data) == 0 || strings.HasSuffix(name, "/") {
+		tx.Rollback()
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
 	}
 
 	err = msgpack.Unmarshal(data, &rf.stat)
 	if err != nil {
-		tx.Commit()
+		tx.Rollback()
 		return nil, err
 	}
-	ibk := rf.stat.Inode.BucketFrom(tx)
-	rf.br = newBlockReader(ibk.Cursor(), rf.stat.BlockSize, rf.stat.Length)
-
+	rf.openData()
 	return &rf, nil
 }
 
+// openData prepares rf to read the file described by rf.stat.
+func (rf *readableFile) openData() {
+	rf.stat.xattrs = readXattrs(rf.tx, rf.stat.Inode)
+	ibk := rf.stat.Inode.BucketFrom(rf.tx)
+	rf.br = newBlockReader(ibk.Cursor(), rf.stat.BlockSize, rf.stat.Length)
+}
+
 func (rf *readableFile) Read(p []byte) (int, error) {
 	if rf.br == nil {
 		return 0, fmt.Errorf("is a directory")
@@ -220,48 +535,108 @@ func (rf *readableFile) Seek(offset int64, whence int) (int64, error) {
 func (rf *readableFile) Stat() (os.FileInfo, error) {
 	return rf.stat, nil
 }
+
+// Readdir follows os.File: entries are returned sorted by name, and with
+// limit > 0 it returns io.EOF once every entry has been returned.
 func (rf *readableFile) Readdir(limit int) ([]os.FileInfo, error) {
 	if rf.bk == nil {
 		return nil, fmt.Errorf("is a file")
 	}
-	if limit == 0 {
-		limit = 1
+	if rf.c == nil {
+		rf.c = rf.bk.Cursor()
+		rf.k, rf.v = rf.c.First()
 	}
 	initialSize := limit
 	if initialSize < 1 {
 		initialSize = 250
 	}
 	inf := make([]os.FileInfo, 0, initialSize)
-	var k, v []byte
-	if rf.c == nil {
-		rf.c = rf.bk.Cursor()
-		k, v = rf.c.First()
-	} else {
-		k, v = rf.c.Next()
-	}
-
-	var err error
-	var stat fileStat
-	for ; k != nil; k, v = rf.c.Next() {
-		name := string(k)
-		if v == nil {
-			inf = append(inf, fileStat{Dir: true, Filename: name})
+	for ; rf.k != nil && (limit <= 0 || len(inf) < limit); rf.k, rf.v = rf.c.Next() {
+		if reservedKey(rf.k) {
 			continue
 		}
-		err = msgpack.Unmarshal(v, &stat)
+		stat, err := entryStat(rf.tx, rf.bk, rf.k, rf.v)
 		if err != nil {
 			return nil, err
 		}
 		inf = append(inf, stat)
-		if len(inf) == limit {
-			break
-		}
 	}
-
+	if limit > 0 && len(inf) == 0 {
+		return nil, io.EOF
+	}
 	return inf, nil
 }
+
+// entryStat returns the stat of the directory entry k, v of bk.
+func entryStat(tx Transaction, bk Bucket, k, v []byte) (fileStat, error) {
+	if v == nil {
+		return fileStat{Dir: true, Filename: dirName(bk.Bucket(k), k)}, nil
+	}
+	var stat fileStat
+	err := msgpack.Unmarshal(v, &stat)
+	if err != nil {
+		return stat, err
+	}
+	if stat.Filename == "" {
+		stat.Filename = string(k)
+	}
+	stat.xattrs = readXattrs(tx, stat.Inode)
+	return stat, nil
+}
+
+// ReadDir implements fs.ReadDirFile.
+func (rf *readableFile) ReadDir(n int) ([]iofs.DirEntry, error) {
+	inf, err := rf.Readdir(n)
+	entries := make([]iofs.DirEntry, len(inf))
+	for i, fi := range inf {
+		entries[i] = iofs.FileInfoToDirEntry(fi)
+	}
+	return entries, err
+}
+
+// seekAfter moves to the first directory entry stored after key.
+func (rf *readableFile) seekAfter(key []byte) {
+	rf.c = rf.bk.Cursor()
+	rf.k, rf.v = rf.c.Seek(key)
+	if bytes.Equal(rf.k, key) {
+		rf.k, rf.v = rf.c.Next()
+	}
+}
+
+// ReadDirFrom lists up to n entries of dir that sort after the name after,
+// so a large directory can be listed in pages by passing the name of the
+// last entry of one page to get the next. An empty after starts at the
+// first entry, and with n <= 0 every remaining entry is returned. Like
+// Readdir, it returns io.EOF for n > 0 when there are no entries left.
+func (fs *boltFs) ReadDirFrom(dir, after string, n int) ([]os.FileInfo, error) {
+	f, err := fs.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	inf, err := f.(*readableFile).readDirAfter(after, n)
+	if err != nil && err != io.EOF {
+		return nil, &os.PathError{Op: "readdir", Path: dir, Err: err}
+	}
+	return inf, err
+}
+
+// readDirAfter is ReadDirFrom for the open directory rf.
+func (rf *readableFile) readDirAfter(after string, n int) ([]os.FileInfo, error) {
+	if rf.bk == nil {
+		return nil, syscall.ENOTDIR
+	}
+	if after != "" {
+		err := checkName(after)
+		if err != nil {
+			return nil, err
+		}
+		rf.seekAfter([]byte(rf.opts.fold(after)))
+	}
+	return rf.Readdir(n)
+}
 func (rf *readableFile) Close() error {
-	rf.tx.Commit()
+	rf.tx.Rollback()
 	rf.tx = nil
 	return nil
 }
@@ -279,7 +654,28 @@ func (s fileStat) Size() int64 {
 	return s.Length
 }
 func (s fileStat) Sys() interface{} {
-	return nil
+	if s.Dir {
+		return nil
+	}
+	return &FileMeta{
+		ContentType:     s.ContentType,
+		ContentEncoding: s.ContentEncoding,
+		ETag:            s.etag(),
+		Xattrs:          s.xattrs,
+	}
+}
+
+// etag is derived from the content hash, falling back to the inode id for
+// files written before hashes were stored. Inodes are never reused, so
+// both are strong validators.
+func (s fileStat) etag() string {
+	if len(s.Hash) > 0 {
+		return `"` + hex.EncodeToString(s.Hash) + `"`
+	}
+	if len(s.Inode) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(`"%s-%x"`, hex.EncodeToString(s.Inode[len(s.Inode)-1]), s.Length)
 }
 func (s fileStat) Mode() os.FileMode {
 	if s.Dir {
@@ -1,11 +1,14 @@
 package boltfs
 
 import (
+	"errors"
 	"github.com/boltdb/bolt"
 	. "github.com/smartystreets/goconvey/convey"
 	"io"
+	iofs "io/fs"
 	"io/ioutil"
 	"os"
+	"syscall"
 	"testing"
 )
 
@@ -132,6 +135,52 @@ func TestBoltFS(t *testing.T) {
 			So(inf[0].Name(), ShouldEqual, "baz")
 
 		})
+		Convey("Should list a directory in pages", func() {
+			for _, name := range []string{"e", "b", "d/x", "a", "c"} {
+				wc, _ := fs.Create(name)
+				wc.Close()
+			}
+			names := func(inf []os.FileInfo) []string {
+				var names []string
+				for _, fi := range inf {
+					names = append(names, fi.Name())
+				}
+				return names
+			}
+
+			f, err := fs.Open("/")
+			So(err, ShouldBeNil)
+			inf, err := f.Readdir(2)
+			So(err, ShouldBeNil)
+			So(names(inf), ShouldResemble, []string{"a", "b"})
+			inf, err = f.Readdir(2)
+			So(err, ShouldBeNil)
+			So(names(inf), ShouldResemble, []string{"c", "d"})
+			inf, err = f.Readdir(0)
+			So(err, ShouldBeNil)
+			So(names(inf), ShouldResemble, []string{"e"})
+			_, err = f.Readdir(2)
+			So(err, ShouldEqual, io.EOF)
+			f.Close()
+
+			f, _ = fs.Open("/")
+			entries, err := f.(iofs.ReadDirFile).ReadDir(3)
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 3)
+			So(entries[2].Name(), ShouldEqual, "c")
+			f.Close()
+
+			inf, err = fs.ReadDirFrom("/", "b", 2)
+			So(err, ShouldBeNil)
+			So(names(inf), ShouldResemble, []string{"c", "d"})
+			inf, err = fs.ReadDirFrom("/", "bb", 0)
+			So(err, ShouldBeNil)
+			So(names(inf), ShouldResemble, []string{"c", "d", "e"})
+			_, err = fs.ReadDirFrom("/", "e", 2)
+			So(err, ShouldEqual, io.EOF)
+			_, err = fs.ReadDirFrom("a", "", 2)
+			So(errors.Is(err, syscall.ENOTDIR), ShouldBeTrue)
+		})
 
 	})
 }
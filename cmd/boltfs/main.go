@@ -0,0 +1,426 @@
+// Command boltfs inspects and modifies a boltfs store in a bolt database.
+//
+//	boltfs [-bucket path] db.db command [arguments]
+//
+// Run boltfs without arguments for the list of commands.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/mastercactapus/boltfs"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: boltfs [-bucket path] db.db command [arguments]
+
+commands:
+  ls [path]                   list a directory
+  stat path                   show file information and xattrs
+  cat path                    write a file to stdout
+  put [-type t] local path    store a local file ("-" for stdin)
+  get path local              copy a file out ("-" for stdout)
+  rm [-r] path                remove a file or directory
+  mv old new                  rename a file or directory
+  mkdir [-p] path             create a directory
+  du [path]                   show the size of a tree
+  fsck [-repair]              check the store for damage
+  import archive.tar [root]   extract a tar archive ("-" for stdin)
+  export root archive         write a tar or .zip archive ("-" for stdout)
+  serve [-addr a] [-readonly] serve files over HTTP
+`
+
+func main() {
+	log.SetFlags(0)
+	err := run(os.Args[1:], os.Stdin, os.Stdout)
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalln("boltfs:", err)
+	}
+}
+
+// run executes a command line, returning flag.ErrHelp after printing usage.
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("boltfs", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), usage) }
+	bucket := flags.String("bucket", "data", "slash separated path of the root bucket")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	db, err := bolt.Open(flags.Arg(0), 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var parts [][]byte
+	for _, part := range strings.Split(*bucket, "/") {
+		if part != "" {
+			parts = append(parts, []byte(part))
+		}
+	}
+	fs, err := boltfs.NewFileSystem(boltfs.NewBoltDB(db), boltfs.NewBucketPath(parts...))
+	if err != nil {
+		return err
+	}
+
+	c := &cmd{fs: fs, stdin: stdin, stdout: stdout}
+	name, args := flags.Arg(1), flags.Args()[2:]
+	fn, ok := map[string]func([]string) error{
+		"ls":     c.ls,
+		"stat":   c.stat,
+		"cat":    c.cat,
+		"put":    c.put,
+		"get":    c.get,
+		"rm":     c.rm,
+		"mv":     c.mv,
+		"mkdir":  c.mkdir,
+		"du":     c.du,
+		"fsck":   c.fsck,
+		"import": c.importTar,
+		"export": c.export,
+		"serve":  c.serve,
+	}[name]
+	if !ok {
+		flags.Usage()
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return fn(args)
+}
+
+type cmd struct {
+	fs     boltfs.Store
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+// parse parses the flags of a command, checking it has between min and max
+// arguments.
+func parse(flags *flag.FlagSet, args []string, min, max int) error {
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() < min || flags.NArg() > max {
+		return fmt.Errorf("%s: expected %d to %d arguments, see boltfs -h", flags.Name(), min, max)
+	}
+	return nil
+}
+
+func arg(flags *flag.FlagSet, i int, def string) string {
+	if flags.NArg() > i {
+		return flags.Arg(i)
+	}
+	return def
+}
+
+func (c *cmd) ls(args []string) error {
+	flags := flag.NewFlagSet("ls", flag.ContinueOnError)
+	err := parse(flags, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	name := arg(flags, 0, "/")
+	fi, err := c.fs.Stat(name)
+	if err != nil {
+		return err
+	}
+	inf := []os.FileInfo{fi}
+	if fi.IsDir() {
+		f, err := c.fs.Open(name)
+		if err != nil {
+			return err
+		}
+		inf, err = f.Readdir(-1)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	tw := tabwriter.NewWriter(c.stdout, 0, 8, 1, ' ', tabwriter.AlignRight)
+	for _, fi := range inf {
+		kind, mtime := "-", ""
+		if fi.IsDir() {
+			kind = "d"
+		} else {
+			mtime = fi.ModTime().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t %s\t %s\t\n", kind, fi.Size(), mtime, fi.Name())
+	}
+	return tw.Flush()
+}
+
+func (c *cmd) stat(args []string) error {
+	flags := flag.NewFlagSet("stat", flag.ContinueOnError)
+	err := parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	fi, err := c.fs.Stat(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "name:  %s\n", fi.Name())
+	if fi.IsDir() {
+		fmt.Fprintln(c.stdout, "type:  directory")
+		return nil
+	}
+	fmt.Fprintln(c.stdout, "type:  file")
+	fmt.Fprintf(c.stdout, "size:  %d\n", fi.Size())
+	fmt.Fprintf(c.stdout, "mtime: %s\n", fi.ModTime().Format(time.RFC3339Nano))
+	meta, ok := fi.Sys().(*boltfs.FileMeta)
+	if !ok {
+		return nil
+	}
+	fmt.Fprintf(c.stdout, "etag:  %s\n", meta.ETag)
+	if meta.ContentType != "" {
+		fmt.Fprintf(c.stdout, "content-type: %s\n", meta.ContentType)
+	}
+	if meta.ContentEncoding != "" {
+		fmt.Fprintf(c.stdout, "content-encoding: %s\n", meta.ContentEncoding)
+	}
+	keys, err := c.fs.ListXattr(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		fmt.Fprintf(c.stdout, "xattr: %s=%q\n", key, meta.Xattrs[key])
+	}
+	return nil
+}
+
+func (c *cmd) cat(args []string) error {
+	flags := flag.NewFlagSet("cat", flag.ContinueOnError)
+	err := parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	return c.copyOut(flags.Arg(0), c.stdout)
+}
+
+func (c *cmd) copyOut(name string, w io.Writer) error {
+	rd, err := boltfs.NewReader(c.fs, name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, rd)
+	return err
+}
+
+func (c *cmd) put(args []string) error {
+	flags := flag.NewFlagSet("put", flag.ContinueOnError)
+	contentType := flags.String("type", "", "content type, guessed from the extension by default")
+	err := parse(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	local, name := flags.Arg(0), flags.Arg(1)
+	opts := &boltfs.CreateOptions{ContentType: *contentType}
+	if opts.ContentType == "" {
+		opts.ContentType = mime.TypeByExtension(path.Ext(name))
+	}
+	r := c.stdin
+	if local != "-" {
+		f, err := os.Open(local)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		opts.ModTime = fi.ModTime()
+		r = f
+	}
+	wc, err := c.fs.CreateWithOptions(name, opts)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(wc, r)
+	if err != nil {
+		if a, ok := wc.(boltfs.Aborter); ok {
+			a.Abort()
+		}
+		return err
+	}
+	return wc.Close()
+}
+
+func (c *cmd) get(args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	err := parse(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	if flags.Arg(1) == "-" {
+		return c.copyOut(flags.Arg(0), c.stdout)
+	}
+	f, err := os.Create(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	err = c.copyOut(flags.Arg(0), f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (c *cmd) rm(args []string) error {
+	flags := flag.NewFlagSet("rm", flag.ContinueOnError)
+	recursive := flags.Bool("r", false, "remove directories and their contents")
+	err := parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if *recursive {
+		return c.fs.RemoveAll(flags.Arg(0))
+	}
+	return c.fs.Remove(flags.Arg(0))
+}
+
+func (c *cmd) mv(args []string) error {
+	flags := flag.NewFlagSet("mv", flag.ContinueOnError)
+	err := parse(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	return c.fs.Rename(flags.Arg(0), flags.Arg(1))
+}
+
+func (c *cmd) mkdir(args []string) error {
+	flags := flag.NewFlagSet("mkdir", flag.ContinueOnError)
+	parents := flags.Bool("p", false, "create parent directories as needed")
+	err := parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if !*parents {
+		return c.fs.Mkdir(flags.Arg(0))
+	}
+	var dir string
+	for _, part := range strings.Split(flags.Arg(0), "/") {
+		if part == "" {
+			continue
+		}
+		dir += "/" + part
+		err = c.fs.Mkdir(dir)
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cmd) du(args []string) error {
+	flags := flag.NewFlagSet("du", flag.ContinueOnError)
+	err := parse(flags, args, 0, 1)
+	if err != nil {
+		return err
+	}
+	u, err := c.fs.Usage(arg(flags, 0, "/"))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "%d bytes in %d files and %d directories\n", u.Bytes, u.Files, u.Directories)
+	return nil
+}
+
+func (c *cmd) fsck(args []string) error {
+	flags := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "delete orphaned inodes")
+	err := parse(flags, args, 0, 0)
+	if err != nil {
+		return err
+	}
+	report, err := c.fs.Fsck(*repair)
+	if err != nil {
+		return err
+	}
+	for _, p := range report.Problems {
+		fmt.Fprintf(c.stdout, "%s: %s\n", p.Path, p.Problem)
+	}
+	fmt.Fprintf(c.stdout, "%d files, %d directories, %d problems, %d orphans", report.Files, report.Directories, len(report.Problems), report.Orphans)
+	if *repair && report.Orphans > 0 {
+		fmt.Fprint(c.stdout, " removed")
+	}
+	fmt.Fprintln(c.stdout)
+	if len(report.Problems) > 0 {
+		return fmt.Errorf("found %d damaged files", len(report.Problems))
+	}
+	return nil
+}
+
+func (c *cmd) importTar(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	skip := flags.Bool("skip", false, "skip entries boltfs cannot store, like symlinks")
+	err := parse(flags, args, 1, 2)
+	if err != nil {
+		return err
+	}
+	r := c.stdin
+	if flags.Arg(0) != "-" {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	return c.fs.ImportTar(r, arg(flags, 1, "/"), &boltfs.ImportOptions{SkipUnsupported: *skip})
+}
+
+func (c *cmd) export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	err := parse(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	root, out := flags.Arg(0), flags.Arg(1)
+	exportFn := c.fs.ExportTar
+	if strings.HasSuffix(out, ".zip") {
+		exportFn = c.fs.ExportZip
+	}
+	if out == "-" {
+		return exportFn(c.stdout, root)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	err = exportFn(f, root)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (c *cmd) serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", ":8000", "address to listen on")
+	readOnly := flags.Bool("readonly", false, "refuse uploads and deletes")
+	err := parse(flags, args, 0, 0)
+	if err != nil {
+		return err
+	}
+	h := boltfs.NewHandler(c.fs, &boltfs.HandlerOptions{ReadOnly: *readOnly})
+	log.Println("listening on", *addr)
+	return http.ListenAndServe(*addr, h)
+}
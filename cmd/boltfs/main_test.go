@@ -0,0 +1,89 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	Convey("When running commands", t, func() {
+		dir, err := ioutil.TempDir("", "boltfs-cmd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		db := filepath.Join(dir, "test.db")
+		boltfs := func(stdin string, args ...string) (string, error) {
+			var out bytes.Buffer
+			err := run(append([]string{"-bucket", "root/files", db}, args...), strings.NewReader(stdin), &out)
+			return out.String(), err
+		}
+		_, err = boltfs("hello", "put", "-", "docs/a.txt")
+		So(err, ShouldBeNil)
+
+		Convey("Should read files back", func() {
+			out, err := boltfs("", "cat", "docs/a.txt")
+			So(err, ShouldBeNil)
+			So(out, ShouldEqual, "hello")
+
+			out, err = boltfs("", "ls", "docs")
+			So(err, ShouldBeNil)
+			So(out, ShouldContainSubstring, "a.txt")
+
+			out, err = boltfs("", "stat", "docs/a.txt")
+			So(err, ShouldBeNil)
+			So(out, ShouldContainSubstring, "size:  5")
+			So(out, ShouldContainSubstring, "content-type: text/plain")
+
+			local := filepath.Join(dir, "a.txt")
+			_, err = boltfs("", "get", "docs/a.txt", local)
+			So(err, ShouldBeNil)
+			data, _ := ioutil.ReadFile(local)
+			So(string(data), ShouldEqual, "hello")
+		})
+		Convey("Should change the namespace", func() {
+			_, err := boltfs("", "mkdir", "-p", "x/y")
+			So(err, ShouldBeNil)
+			_, err = boltfs("", "mv", "docs/a.txt", "x/y/b.txt")
+			So(err, ShouldBeNil)
+			out, err := boltfs("", "du")
+			So(err, ShouldBeNil)
+			So(out, ShouldEqual, "5 bytes in 1 files and 3 directories\n")
+
+			_, err = boltfs("", "rm", "x")
+			So(err, ShouldNotBeNil)
+			_, err = boltfs("", "rm", "-r", "x")
+			So(err, ShouldBeNil)
+			_, err = boltfs("", "cat", "x/y/b.txt")
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+		Convey("Should export and import archives", func() {
+			out, err := boltfs("", "export", "docs", "-")
+			So(err, ShouldBeNil)
+			hdr, err := tar.NewReader(strings.NewReader(out)).Next()
+			So(err, ShouldBeNil)
+			So(hdr.Name, ShouldEqual, "a.txt")
+
+			_, err = boltfs(out, "import", "-", "copy")
+			So(err, ShouldBeNil)
+			out, err = boltfs("", "cat", "copy/a.txt")
+			So(err, ShouldBeNil)
+			So(out, ShouldEqual, "hello")
+		})
+		Convey("Should check the store", func() {
+			out, err := boltfs("", "fsck")
+			So(err, ShouldBeNil)
+			So(out, ShouldEqual, "1 files, 1 directories, 0 problems, 0 orphans\n")
+		})
+		Convey("Should reject unknown commands", func() {
+			_, err := boltfs("", "frobnicate")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
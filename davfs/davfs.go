@@ -0,0 +1,273 @@
+// Package davfs serves a boltfs FileSystem over WebDAV using
+// golang.org/x/net/webdav.
+package davfs
+
+import (
+	"context"
+	"fmt"
+	"github.com/mastercactapus/boltfs"
+	"golang.org/x/net/webdav"
+	"io"
+	"os"
+	"path"
+	"syscall"
+	"time"
+)
+
+type fileSystem struct {
+	fs boltfs.FileSystem
+}
+
+// New returns a webdav.FileSystem backed by fs.
+//
+// Files opened for writing are replaced atomically when closed, like
+// boltfs.FileSystem.Create. Opening an existing file for writing without
+// O_TRUNC copies its contents first, so writes are appended.
+func New(fs boltfs.FileSystem) webdav.FileSystem {
+	return &fileSystem{fs: fs}
+}
+
+func (d *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return d.fs.Mkdir(name)
+}
+
+func (d *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	return d.fs.RemoveAll(name)
+}
+
+func (d *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	return d.fs.Rename(oldName, newName)
+}
+
+func (d *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	fi, err := d.fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{fi}, nil
+}
+
+func (d *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return openReadFile(d.fs, name)
+	}
+
+	fi, err := d.fs.Stat(name)
+	exists := err == nil
+	switch {
+	case err != nil && !os.IsNotExist(err):
+		return nil, err
+	case !exists && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case exists && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case exists && fi.IsDir():
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+	if !exists {
+		// unlike boltfs, WebDAV does not create missing parent collections
+		dir, err := d.fs.Stat(path.Dir(name))
+		if err != nil {
+			return nil, err
+		}
+		if !dir.IsDir() {
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOTDIR}
+		}
+	}
+
+	wc, err := d.fs.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	wf := &writeFile{wc: wc, name: path.Base(name), mtime: time.Now()}
+	if exists && flag&os.O_TRUNC == 0 {
+		err = wf.copyFrom(d.fs, name)
+		if err != nil {
+			wf.abort()
+			return nil, err
+		}
+	}
+	return wf, nil
+}
+
+// fileInfo adds the webdav.ETager and webdav.ContentTyper interfaces using
+// metadata stored by boltfs.
+type fileInfo struct {
+	os.FileInfo
+}
+
+func (fi fileInfo) meta() *boltfs.FileMeta {
+	meta, _ := fi.Sys().(*boltfs.FileMeta)
+	return meta
+}
+
+func (fi fileInfo) ETag(ctx context.Context) (string, error) {
+	if meta := fi.meta(); meta != nil && meta.ETag != "" {
+		return meta.ETag, nil
+	}
+	return "", webdav.ErrNotImplemented
+}
+
+func (fi fileInfo) ContentType(ctx context.Context) (string, error) {
+	if meta := fi.meta(); meta != nil && meta.ContentType != "" {
+		return meta.ContentType, nil
+	}
+	return "", webdav.ErrNotImplemented
+}
+
+// readFile does not keep a boltfs file open between calls. webdav holds
+// source files open while writing copies, which can block bolt forever, so
+// directories are listed up front and files are read with a boltfs.Reader.
+type readFile struct {
+	*boltfs.Reader
+	name    string
+	fi      os.FileInfo
+	entries []os.FileInfo
+}
+
+func openReadFile(fs boltfs.FileSystem, name string) (*readFile, error) {
+	fi, err := fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	rf := &readFile{name: name, fi: fileInfo{fi}}
+	if !fi.IsDir() {
+		rf.Reader, err = boltfs.NewReader(fs, name)
+		if err != nil {
+			return nil, err
+		}
+		rf.fi = fileInfo{rf.Reader.Stat()}
+		return rf, nil
+	}
+
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rf.entries, err = f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rf.entries {
+		rf.entries[i] = fileInfo{rf.entries[i]}
+	}
+	return rf, nil
+}
+
+func (f *readFile) Read(p []byte) (int, error) {
+	if f.Reader == nil {
+		return 0, fmt.Errorf("read %s: is a directory", f.name)
+	}
+	return f.Reader.Read(p)
+}
+
+func (f *readFile) Seek(offset int64, whence int) (int64, error) {
+	if f.Reader == nil {
+		return 0, fmt.Errorf("seek %s: is a directory", f.name)
+	}
+	return f.Reader.Seek(offset, whence)
+}
+
+// Readdir follows os.File, as webdav expects: it returns all remaining
+// entries for count <= 0, and io.EOF for count > 0 once none are left.
+func (f *readFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.fi.IsDir() {
+		return nil, fmt.Errorf("readdir %s: not a directory", f.name)
+	}
+	if count > 0 && len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count <= 0 || count > len(f.entries) {
+		count = len(f.entries)
+	}
+	inf := f.entries[:count]
+	f.entries = f.entries[count:]
+	return inf, nil
+}
+
+func (f *readFile) Stat() (os.FileInfo, error) {
+	return f.fi, nil
+}
+
+func (f *readFile) Write([]byte) (int, error) {
+	return 0, fmt.Errorf("write %s: file not opened for writing", f.name)
+}
+
+func (f *readFile) Close() error {
+	return nil
+}
+
+// writeFile publishes its contents when closed, unless a write failed.
+type writeFile struct {
+	wc     io.WriteCloser
+	name   string
+	size   int64
+	mtime  time.Time
+	failed bool
+}
+
+func (f *writeFile) copyFrom(fs boltfs.FileSystem, name string) error {
+	r, err := openReadFile(fs, name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func (f *writeFile) Write(p []byte) (int, error) {
+	n, err := f.wc.Write(p)
+	f.size += int64(n)
+	f.mtime = time.Now()
+	if err != nil {
+		f.failed = true
+	}
+	return n, err
+}
+
+func (f *writeFile) abort() error {
+	if a, ok := f.wc.(boltfs.Aborter); ok {
+		return a.Abort()
+	}
+	return f.wc.Close()
+}
+
+func (f *writeFile) Close() error {
+	if f.failed {
+		f.abort()
+		return fmt.Errorf("write %s: not saved after failed write", f.name)
+	}
+	return f.wc.Close()
+}
+
+func (f *writeFile) Read([]byte) (int, error) {
+	return 0, fmt.Errorf("file not opened for reading")
+}
+
+// Seek only reports the current position, which is always the end.
+func (f *writeFile) Seek(offset int64, whence int) (int64, error) {
+	if (whence == io.SeekCurrent || whence == io.SeekEnd) && offset == 0 {
+		return f.size, nil
+	}
+	return 0, fmt.Errorf("seek not supported while writing")
+}
+
+func (f *writeFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, fmt.Errorf("not a directory")
+}
+
+func (f *writeFile) Stat() (os.FileInfo, error) {
+	return writeInfo{f}, nil
+}
+
+type writeInfo struct {
+	f *writeFile
+}
+
+func (fi writeInfo) Name() string       { return fi.f.name }
+func (fi writeInfo) Size() int64        { return fi.f.size }
+func (fi writeInfo) Mode() os.FileMode  { return 0666 }
+func (fi writeInfo) ModTime() time.Time { return fi.f.mtime }
+func (fi writeInfo) IsDir() bool        { return false }
+func (fi writeInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,115 @@
+package davfs
+
+import (
+	"github.com/mastercactapus/boltfs"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/webdav"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDavFS(t *testing.T) {
+	Convey("When serving boltfs over WebDAV", t, func() {
+		db, fs, done := openTestFS(t, "davfs")
+		defer done()
+		ls, err := NewLockSystem(boltfs.NewBoltDB(db), boltfs.NewBucketPath([]byte("locks")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := httptest.NewServer(&webdav.Handler{FileSystem: New(fs), LockSystem: ls})
+		defer srv.Close()
+
+		do := func(method, name, body string, hdr map[string]string) (int, string) {
+			req, err := http.NewRequest(method, srv.URL+name, strings.NewReader(body))
+			So(err, ShouldBeNil)
+			for k, v := range hdr {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			data, _ := ioutil.ReadAll(resp.Body)
+			return resp.StatusCode, string(data)
+		}
+
+		Convey("Should create collections and files", func() {
+			code, _ := do("MKCOL", "/docs", "", nil)
+			So(code, ShouldEqual, http.StatusCreated)
+			code, _ = do("MKCOL", "/missing/docs", "", nil)
+			So(code, ShouldEqual, http.StatusConflict)
+			code, _ = do("PUT", "/missing/a.txt", "hello", nil)
+			So(code, ShouldEqual, http.StatusConflict)
+			_, err := fs.Stat("missing")
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			code, _ = do("PUT", "/docs/a.txt", "hello", nil)
+			So(code, ShouldEqual, http.StatusCreated)
+			code, body := do("GET", "/docs/a.txt", "", nil)
+			So(code, ShouldEqual, http.StatusOK)
+			So(body, ShouldEqual, "hello")
+
+			code, body = do("PROPFIND", "/docs", "", map[string]string{"Depth": "1"})
+			So(code, ShouldEqual, http.StatusMultiStatus)
+			So(body, ShouldContainSubstring, "/docs/a.txt")
+		})
+		Convey("Should move, copy and delete", func() {
+			do("MKCOL", "/docs", "", nil)
+			do("PUT", "/docs/a.txt", "hello", nil)
+
+			code, _ := do("MOVE", "/docs", "", map[string]string{"Destination": srv.URL + "/moved"})
+			So(code, ShouldEqual, http.StatusCreated)
+			code, body := do("GET", "/moved/a.txt", "", nil)
+			So(code, ShouldEqual, http.StatusOK)
+			So(body, ShouldEqual, "hello")
+
+			code, _ = do("COPY", "/moved/a.txt", "", map[string]string{"Destination": srv.URL + "/b.txt"})
+			So(code, ShouldEqual, http.StatusCreated)
+			_, body = do("GET", "/b.txt", "", nil)
+			So(body, ShouldEqual, "hello")
+
+			code, _ = do("DELETE", "/moved", "", nil)
+			So(code, ShouldEqual, http.StatusNoContent)
+			code, _ = do("GET", "/moved/a.txt", "", nil)
+			So(code, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Should refuse writes to locked files", func() {
+			do("PUT", "/a.txt", "hello", nil)
+			lockBody := `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+			code, _ := do("LOCK", "/a.txt", lockBody, nil)
+			So(code, ShouldEqual, http.StatusOK)
+			code, _ = do("PUT", "/a.txt", "changed", nil)
+			So(code, ShouldEqual, http.StatusLocked)
+		})
+	})
+	Convey("When opening files for writing", t, func() {
+		_, fs, done := openTestFS(t, "davfs")
+		defer done()
+		dfs := New(fs)
+		wc, _ := fs.Create("a.txt")
+		io.WriteString(wc, "hello")
+		wc.Close()
+
+		Convey("Should append without O_TRUNC", func() {
+			f, err := dfs.OpenFile(nil, "a.txt", os.O_RDWR|os.O_APPEND, 0666)
+			So(err, ShouldBeNil)
+			io.WriteString(f, " world")
+			So(f.Close(), ShouldBeNil)
+			f, err = dfs.OpenFile(nil, "a.txt", os.O_RDONLY, 0)
+			So(err, ShouldBeNil)
+			data, _ := ioutil.ReadAll(f)
+			f.Close()
+			So(string(data), ShouldEqual, "hello world")
+		})
+		Convey("Should honor O_CREATE and O_EXCL", func() {
+			_, err := dfs.OpenFile(nil, "b.txt", os.O_WRONLY, 0666)
+			So(os.IsNotExist(err), ShouldBeTrue)
+			_, err = dfs.OpenFile(nil, "a.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+			So(os.IsExist(err), ShouldBeTrue)
+		})
+	})
+}
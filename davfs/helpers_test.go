@@ -0,0 +1,34 @@
+package davfs
+
+import (
+	"github.com/boltdb/bolt"
+	"github.com/mastercactapus/boltfs"
+	"os"
+	"testing"
+)
+
+// openTestDB opens a new <name>_test.db. The returned function closes and
+// removes it.
+func openTestDB(t *testing.T, name string) (*bolt.DB, func()) {
+	file := name + "_test.db"
+	os.Remove(file)
+	db, err := bolt.Open(file, 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.Remove(file)
+	}
+}
+
+// openTestFS opens a boltfs in the "test" bucket of a new <name>_test.db.
+func openTestFS(t *testing.T, name string) (*bolt.DB, boltfs.Store, func()) {
+	db, done := openTestDB(t, name)
+	fs, err := boltfs.NewFileSystem(boltfs.NewBoltDB(db), boltfs.NewBucketPath([]byte("test")))
+	if err != nil {
+		done()
+		t.Fatal(err)
+	}
+	return db, fs, done
+}
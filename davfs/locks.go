@@ -0,0 +1,223 @@
+package davfs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/mastercactapus/boltfs"
+	"golang.org/x/net/webdav"
+	"gopkg.in/vmihailenco/msgpack.v2"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+type lockSystem struct {
+	db   boltfs.DB
+	path boltfs.BucketPath
+
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+type lockRecord struct {
+	Root      string
+	Duration  time.Duration
+	OwnerXML  string
+	ZeroDepth bool
+	Expiry    time.Time
+}
+
+// NewLockSystem returns a webdav.LockSystem that stores locks in the bucket
+// at path, so they survive restarts. Claims made through Confirm are only
+// tracked in memory.
+func NewLockSystem(db boltfs.DB, path boltfs.BucketPath) (webdav.LockSystem, error) {
+	err := db.Update(func(tx boltfs.Transaction) error {
+		_, err := path.MkFrom(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &lockSystem{db: db, path: path, held: make(map[string]bool)}, nil
+}
+
+func slashClean(name string) string {
+	return path.Clean("/" + name)
+}
+
+func (l lockRecord) details() webdav.LockDetails {
+	return webdav.LockDetails{Root: l.Root, Duration: l.Duration, OwnerXML: l.OwnerXML, ZeroDepth: l.ZeroDepth}
+}
+
+func (l lockRecord) expired(now time.Time) bool {
+	return l.Duration >= 0 && !now.Before(l.Expiry)
+}
+
+// covers reports if the lock applies to name.
+func (l lockRecord) covers(name string) bool {
+	if name == l.Root {
+		return true
+	}
+	if l.ZeroDepth {
+		return false
+	}
+	return l.Root == "/" || strings.HasPrefix(name, l.Root+"/")
+}
+
+// update runs fn with all unexpired locks, deleting expired ones that are
+// not held first.
+func (ls *lockSystem) update(now time.Time, fn func(bk boltfs.Bucket, locks map[string]lockRecord) error) error {
+	return ls.db.Update(func(tx boltfs.Transaction) error {
+		bk := ls.path.BucketFrom(tx)
+		locks := make(map[string]lockRecord)
+		var expired [][]byte
+		c := bk.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var l lockRecord
+			err := msgpack.Unmarshal(v, &l)
+			if err != nil {
+				return err
+			}
+			if l.expired(now) && !ls.held[string(k)] {
+				expired = append(expired, append([]byte{}, k...))
+				continue
+			}
+			locks[string(k)] = l
+		}
+		for _, k := range expired {
+			err := bk.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		return fn(bk, locks)
+	})
+}
+
+func putLock(bk boltfs.Bucket, token string, l lockRecord) error {
+	data, err := msgpack.Marshal(&l)
+	if err != nil {
+		return err
+	}
+	return bk.Put([]byte(token), data)
+}
+
+func (ls *lockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	var t0, t1 string
+	err := ls.update(now, func(bk boltfs.Bucket, locks map[string]lockRecord) error {
+		lookup := func(name string) string {
+			name = slashClean(name)
+			for _, c := range conditions {
+				l, ok := locks[c.Token]
+				if ok && !ls.held[c.Token] && l.covers(name) {
+					return c.Token
+				}
+			}
+			return ""
+		}
+		if name0 != "" {
+			if t0 = lookup(name0); t0 == "" {
+				return webdav.ErrConfirmationFailed
+			}
+		}
+		if name1 != "" {
+			if t1 = lookup(name1); t1 == "" {
+				return webdav.ErrConfirmationFailed
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if t0 != "" {
+		ls.held[t0] = true
+	}
+	if t1 != "" {
+		ls.held[t1] = true
+	}
+	return func() {
+		ls.mu.Lock()
+		defer ls.mu.Unlock()
+		delete(ls.held, t0)
+		delete(ls.held, t1)
+	}, nil
+}
+
+func (ls *lockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	details.Root = slashClean(details.Root)
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	token := "urn:boltfs-lock:" + hex.EncodeToString(buf)
+
+	err = ls.update(now, func(bk boltfs.Bucket, locks map[string]lockRecord) error {
+		for _, l := range locks {
+			// an existing lock covering the new root, or a new infinite
+			// lock covering an existing one, conflicts
+			if l.covers(details.Root) {
+				return webdav.ErrLocked
+			}
+			if !details.ZeroDepth && (details.Root == "/" || strings.HasPrefix(l.Root, details.Root+"/")) {
+				return webdav.ErrLocked
+			}
+		}
+		l := lockRecord{Root: details.Root, Duration: details.Duration, OwnerXML: details.OwnerXML, ZeroDepth: details.ZeroDepth}
+		if l.Duration >= 0 {
+			l.Expiry = now.Add(l.Duration)
+		}
+		return putLock(bk, token, l)
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (ls *lockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	var details webdav.LockDetails
+	err := ls.update(now, func(bk boltfs.Bucket, locks map[string]lockRecord) error {
+		l, ok := locks[token]
+		if !ok {
+			return webdav.ErrNoSuchLock
+		}
+		if ls.held[token] {
+			return webdav.ErrLocked
+		}
+		l.Duration = duration
+		if l.Duration >= 0 {
+			l.Expiry = now.Add(duration)
+		}
+		details = l.details()
+		return putLock(bk, token, l)
+	})
+	return details, err
+}
+
+func (ls *lockSystem) Unlock(now time.Time, token string) error {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	return ls.update(now, func(bk boltfs.Bucket, locks map[string]lockRecord) error {
+		if _, ok := locks[token]; !ok {
+			return webdav.ErrNoSuchLock
+		}
+		if ls.held[token] {
+			return webdav.ErrLocked
+		}
+		return bk.Delete([]byte(token))
+	})
+}
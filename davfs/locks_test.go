@@ -0,0 +1,55 @@
+package davfs
+
+import (
+	"github.com/mastercactapus/boltfs"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/webdav"
+	"testing"
+	"time"
+)
+
+func TestLockSystem(t *testing.T) {
+	Convey("When locking resources", t, func() {
+		db, done := openTestDB(t, "locks")
+		defer done()
+		ls, err := NewLockSystem(boltfs.NewBoltDB(db), boltfs.NewBucketPath([]byte("locks")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		token, err := ls.Create(now, webdav.LockDetails{Root: "/a", Duration: time.Minute})
+		So(err, ShouldBeNil)
+
+		Convey("Should reject conflicting locks", func() {
+			_, err := ls.Create(now, webdav.LockDetails{Root: "/a/b", ZeroDepth: true, Duration: -1})
+			So(err, ShouldEqual, webdav.ErrLocked)
+			_, err = ls.Create(now, webdav.LockDetails{Root: "/", Duration: -1})
+			So(err, ShouldEqual, webdav.ErrLocked)
+			_, err = ls.Create(now, webdav.LockDetails{Root: "/", ZeroDepth: true, Duration: -1})
+			So(err, ShouldBeNil)
+		})
+		Convey("Should confirm matching tokens and hold them", func() {
+			_, err := ls.Confirm(now, "/a/b", "", webdav.Condition{Token: "nope"})
+			So(err, ShouldEqual, webdav.ErrConfirmationFailed)
+			release, err := ls.Confirm(now, "/a/b", "", webdav.Condition{Token: token})
+			So(err, ShouldBeNil)
+			So(ls.Unlock(now, token), ShouldEqual, webdav.ErrLocked)
+			release()
+			So(ls.Unlock(now, token), ShouldBeNil)
+			So(ls.Unlock(now, token), ShouldEqual, webdav.ErrNoSuchLock)
+		})
+		Convey("Should persist locks across instances", func() {
+			ls2, err := NewLockSystem(boltfs.NewBoltDB(db), boltfs.NewBucketPath([]byte("locks")))
+			So(err, ShouldBeNil)
+			details, err := ls2.Refresh(now, token, time.Hour)
+			So(err, ShouldBeNil)
+			So(details.Root, ShouldEqual, "/a")
+		})
+		Convey("Should expire locks", func() {
+			_, err := ls.Refresh(now.Add(time.Hour), token, time.Minute)
+			So(err, ShouldEqual, webdav.ErrNoSuchLock)
+			_, err = ls.Create(now.Add(time.Hour), webdav.LockDetails{Root: "/a", Duration: -1})
+			So(err, ShouldBeNil)
+		})
+	})
+}
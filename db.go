@@ -1,6 +1,7 @@
 package boltfs
 
 import (
+	"bytes"
 	"github.com/boltdb/bolt"
 )
 
@@ -29,6 +30,7 @@ type Bucket interface {
 	Bucketer
 	Put(key, val []byte) error
 	Get(key []byte) []byte
+	Delete(key []byte) error
 }
 
 type Cursor interface {
@@ -130,3 +132,11 @@ func (bk *boltBk) Get(key []byte) []byte {
 func (bk *boltBk) Put(key, val []byte) error {
 	return bk.bk.Put(key, val)
 }
+func (bk *boltBk) Delete(key []byte) error {
+	// bolt does not check that the key it seeks to matches, so deleting a
+	// missing key followed by a nested bucket fails
+	if k, _ := bk.bk.Cursor().Seek(key); !bytes.Equal(k, key) {
+		return nil
+	}
+	return bk.bk.Delete(key)
+}
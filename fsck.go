@@ -0,0 +1,175 @@
+package boltfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"gopkg.in/vmihailenco/msgpack.v2"
+	"os"
+)
+
+// FsckProblem describes damage found by Fsck.
+type FsckProblem struct {
+	Path    string
+	Problem string
+}
+
+// FsckReport is the result of Fsck.
+type FsckReport struct {
+	Files       int
+	Directories int
+	Problems    []FsckProblem
+
+	// Orphans is the number of inodes and metadata buckets that no file
+	// or upload refers to. They are deleted when Fsck is asked to repair.
+	Orphans int
+}
+
+// Fsck checks that every file has all of its data and that no two entries
+// of a directory share a name, and looks for inodes left behind by
+// interrupted writes. With repair set, orphaned inodes are
+// deleted; damaged files are only reported. Files being written are not
+// referenced until they are closed, so only repair while nothing is
+// writing to the FileSystem. The store is shared by every FileSystem
+// returned by Sub, so they cannot check it.
+func (fs *boltFs) Fsck(repair bool) (*FsckReport, error) {
+	if fs.isSub() {
+		return nil, &os.PathError{Op: "fsck", Path: "/", Err: os.ErrPermission}
+	}
+	txFn := fs.db.View
+	if repair {
+		txFn = fs.db.Update
+	}
+	var report *FsckReport
+	err := txFn(func(tx Transaction) error {
+		report = &FsckReport{}
+		used := make(map[string]bool)
+		seen := make(map[string]bool)
+		fsBk := fs.path.Join([]byte(fsKey)).BucketFrom(tx)
+		err := walkStats(fsBk, "/", func(name string, stat *fileStat) error {
+			if dir, ok := seen[name]; ok {
+				problem := "name used by more than one entry"
+				if dir != stat.Dir {
+					problem = "file and directory share a name"
+				}
+				report.Problems = append(report.Problems, FsckProblem{name, problem})
+			}
+			seen[name] = stat.Dir
+			if stat.Dir {
+				report.Directories++
+				return nil
+			}
+			report.Files++
+			if len(stat.Inode) == 0 {
+				report.Problems = append(report.Problems, FsckProblem{name, "no inode"})
+				return nil
+			}
+			used[string(stat.Inode[len(stat.Inode)-1])] = true
+			if problem := checkBlocks(tx, stat); problem != "" {
+				report.Problems = append(report.Problems, FsckProblem{name, problem})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		markUsed := func(stat *fileStat) error {
+			if len(stat.Inode) > 0 {
+				used[string(stat.Inode[len(stat.Inode)-1])] = true
+			}
+			return nil
+		}
+		err = walkVersions(fsBk, markUsed)
+		if err != nil {
+			return err
+		}
+		err = fs.walkTrash(tx, markUsed)
+		if err != nil {
+			return err
+		}
+		err = fs.walkSnapshots(tx, markUsed)
+		if err != nil {
+			return err
+		}
+		err = fs.walkStaging(tx, markUsed)
+		if err != nil {
+			return err
+		}
+
+		c := fs.uploadsPath().BucketFrom(tx).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var rec uploadRecord
+			err = msgpack.Unmarshal(v, &rec)
+			if err != nil {
+				report.Problems = append(report.Problems, FsckProblem{"upload " + string(k), "unreadable upload record"})
+				continue
+			}
+			if len(rec.Inode) > 0 {
+				used[string(rec.Inode[len(rec.Inode)-1])] = true
+			}
+		}
+
+		for _, key := range []string{inodesKey, metaKey} {
+			bk := fs.path.Join([]byte(key)).BucketFrom(tx)
+			var orphans [][]byte
+			c := bk.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				if !used[string(k)] {
+					orphans = append(orphans, append([]byte{}, k...))
+				}
+			}
+			report.Orphans += len(orphans)
+			if !repair {
+				continue
+			}
+			for _, k := range orphans {
+				err = bk.DeleteBucket(k)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// checkBlocks returns a description of what is wrong with the blocks of a
+// file, or an empty string.
+func checkBlocks(tx Transaction, stat *fileStat) string {
+	bk := stat.Inode.BucketFrom(tx)
+	if bk == nil {
+		return "missing inode"
+	}
+	if stat.BlockSize <= 0 {
+		return fmt.Sprintf("invalid block size %d", stat.BlockSize)
+	}
+	// block keys are little endian, so a cursor does not visit them in order
+	blocks := (stat.Length + stat.BlockSize - 1) / stat.BlockSize
+	key := make([]byte, 8)
+	for block := int64(0); block < blocks; block++ {
+		binary.LittleEndian.PutUint64(key, uint64(block))
+		v := bk.Get(key)
+		if v == nil {
+			return fmt.Sprintf("missing block %d", block)
+		}
+		want := stat.BlockSize
+		if block == blocks-1 {
+			want = stat.Length - block*stat.BlockSize
+		}
+		if int64(len(v)) != want {
+			return fmt.Sprintf("block %d has %d of %d bytes", block, len(v), want)
+		}
+	}
+	var n int64
+	c := bk.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		n++
+	}
+	if n != blocks {
+		return fmt.Sprintf("has %d blocks, expected %d", n, blocks)
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package boltfs
+
+import (
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	Convey("When checking a filesystem", t, func() {
+		os.Remove("fsck_test.db")
+		defer os.Remove("fsck_test.db")
+		db, err := bolt.Open("fsck_test.db", 0644, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fs, err := NewFileSystem(NewBoltDB(db), NewBucketPath([]byte("test")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wc, _ := fs.Create("dir/a")
+		io.WriteString(wc, strings.Repeat("x", int(blockSize)+5))
+		wc.Close()
+		_, err = fs.CreateUpload("pending", 10, nil)
+		So(err, ShouldBeNil)
+
+		Convey("Should find no problems in a healthy tree", func() {
+			report, err := fs.Fsck(false)
+			So(err, ShouldBeNil)
+			So(report.Files, ShouldEqual, 1)
+			So(report.Directories, ShouldEqual, 1)
+			So(report.Problems, ShouldBeEmpty)
+			So(report.Orphans, ShouldEqual, 0)
+		})
+		Convey("Should find and remove orphaned inodes", func() {
+			wc, _ := fs.Create("abandoned")
+			wc.Write([]byte(strings.Repeat("y", int(blockSize)*2)))
+			// never closed
+
+			report, err := fs.Fsck(false)
+			So(err, ShouldBeNil)
+			So(report.Orphans, ShouldEqual, 1)
+			report, err = fs.Fsck(true)
+			So(err, ShouldBeNil)
+			So(report.Orphans, ShouldEqual, 1)
+			report, err = fs.Fsck(false)
+			So(err, ShouldBeNil)
+			So(report.Orphans, ShouldEqual, 0)
+		})
+		Convey("Should report missing blocks", func() {
+			db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("test")).Bucket([]byte(inodesKey)).Bucket(make([]byte, 8)).Delete(make([]byte, 8))
+			})
+			report, err := fs.Fsck(false)
+			So(err, ShouldBeNil)
+			So(report.Problems, ShouldResemble, []FsckProblem{{"/dir/a", "missing block 0"}})
+		})
+		Convey("Should report a file and directory with the same name", func() {
+			wc, _ := fs.Create("b")
+			wc.Close()
+			db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("test")).Bucket([]byte(fsKey)).Bucket([]byte("dir")).Put([]byte(dirNameKey), []byte("b"))
+			})
+			report, err := fs.Fsck(false)
+			So(err, ShouldBeNil)
+			So(report.Problems, ShouldResemble, []FsckProblem{{"/b", "file and directory share a name"}})
+		})
+		Convey("Should refuse to check the store from a Sub", func() {
+			sub, err := fs.Sub("dir")
+			So(err, ShouldBeNil)
+			_, err = sub.Fsck(true)
+			So(os.IsPermission(err), ShouldBeTrue)
+		})
+	})
+}
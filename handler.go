@@ -0,0 +1,374 @@
+package boltfs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Handler serves files from a FileSystem using the content type, encoding
+// and entity tag stored with each file. Conditional requests are answered
+// from the file stat alone, without reading any blocks.
+//
+// Unless ReadOnly is set, PUT and POST store the request body as a file,
+// published atomically once the upload completes, and DELETE removes a file
+// or empty directory. Directory listings are returned as JSON when the
+// request accepts application/json. A listing is paged with the limit query
+// parameter, and the next page starts after the name given as after.
+type Handler struct {
+	fs   FileSystem
+	dirs http.Handler
+	opts HandlerOptions
+}
+
+// HandlerOptions configure a Handler.
+type HandlerOptions struct {
+	// Directories handles requests for directories that do not ask for
+	// JSON. It defaults to http.FileServer for the FileSystem.
+	Directories http.Handler
+
+	// ReadOnly rejects uploads and deletes.
+	ReadOnly bool
+
+	// MaxUploadSize limits the size of uploaded files, if greater than zero.
+	MaxUploadSize int64
+
+	// Authorize is called before every request with the cleaned file name.
+	// A non-nil error is returned to the client as 403 Forbidden.
+	Authorize func(r *http.Request, name string) error
+}
+
+// DirEntry is an element of a JSON directory listing.
+type DirEntry struct {
+	Name        string    `json:"name"`
+	Dir         bool      `json:"dir"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"modTime"`
+	ContentType string    `json:"contentType,omitempty"`
+	ETag        string    `json:"etag,omitempty"`
+}
+
+// NewHandler returns a Handler serving fs. opts may be nil.
+func NewHandler(fs FileSystem, opts *HandlerOptions) *Handler {
+	h := &Handler{fs: fs}
+	if opts != nil {
+		h.opts = *opts
+	}
+	h.dirs = h.opts.Directories
+	if h.dirs == nil {
+		h.dirs = http.FileServer(fs)
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+	if h.opts.Authorize != nil {
+		err := h.opts.Authorize(r, name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	switch r.Method {
+	case "GET", "HEAD":
+		h.serveGet(w, r, name)
+	case "PUT", "POST":
+		if h.opts.ReadOnly {
+			h.notAllowed(w)
+			return
+		}
+		h.serveUpload(w, r, name)
+	case "DELETE":
+		if h.opts.ReadOnly {
+			h.notAllowed(w)
+			return
+		}
+		h.serveDelete(w, r, name)
+	default:
+		h.notAllowed(w)
+	}
+}
+
+func (h *Handler) notAllowed(w http.ResponseWriter) {
+	if h.opts.ReadOnly {
+		w.Header().Set("Allow", "GET, HEAD")
+	} else {
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST, DELETE")
+	}
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+func (h *Handler) serveGet(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := h.fs.Open(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !fi.IsDir() {
+		serveFile(w, r, name, f, fi)
+		return
+	}
+	if !acceptsJSON(r) {
+		h.dirs.ServeHTTP(w, r)
+		return
+	}
+	var inf []os.FileInfo
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		n, perr := strconv.Atoi(limit)
+		if perr != nil || n < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		inf, err = readDirAfter(f, r.URL.Query().Get("after"), n)
+	} else {
+		inf, err = f.Readdir(-1)
+	}
+	if err != nil && err != io.EOF {
+		writeError(w, err)
+		return
+	}
+	entries := make([]DirEntry, 0, len(inf))
+	for _, fi := range inf {
+		e := DirEntry{Name: fi.Name(), Dir: fi.IsDir(), Size: fi.Size(), ModTime: fi.ModTime()}
+		if meta, ok := fi.Sys().(*FileMeta); ok {
+			e.ContentType = meta.ContentType
+			e.ETag = meta.ETag
+		}
+		entries = append(entries, e)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "HEAD" {
+		return
+	}
+	json.NewEncoder(w).Encode(entries)
+}
+
+func (h *Handler) serveUpload(w http.ResponseWriter, r *http.Request, name string) {
+	if strings.HasSuffix(r.URL.Path, "/") || name == "/" {
+		http.Error(w, "cannot upload to a directory", http.StatusConflict)
+		return
+	}
+	max := h.opts.MaxUploadSize
+	body := r.Body
+	if max > 0 {
+		if r.ContentLength > max {
+			http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		body = http.MaxBytesReader(w, r.Body, max)
+	}
+
+	_, err := h.fs.Stat(name)
+	existed := err == nil
+
+	wc, err := h.fs.CreateWithOptions(name, &CreateOptions{
+		ContentType:     r.Header.Get("Content-Type"),
+		ContentEncoding: r.Header.Get("Content-Encoding"),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	_, err = io.Copy(wc, body)
+	if err != nil {
+		if a, ok := wc.(Aborter); ok {
+			a.Abort()
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if errors.Is(err, ErrQuotaExceeded) {
+			writeError(w, err)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = wc.Close()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if fi, err := h.fs.Stat(name); err == nil {
+		if meta, ok := fi.Sys().(*FileMeta); ok && meta.ETag != "" {
+			w.Header().Set("ETag", meta.ETag)
+		}
+	}
+	if existed {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (h *Handler) serveDelete(w http.ResponseWriter, r *http.Request, name string) {
+	err := h.fs.Remove(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// dirPager is implemented by directories that can start listing after a
+// given name.
+type dirPager interface {
+	readDirAfter(after string, n int) ([]os.FileInfo, error)
+}
+
+// readDirAfter lists up to n entries of the open directory f that sort
+// after the name after. Directories of other FileSystems are listed in
+// full and then filtered.
+func readDirAfter(f http.File, after string, n int) ([]os.FileInfo, error) {
+	if p, ok := f.(dirPager); ok {
+		return p.readDirAfter(after, n)
+	}
+	inf, err := f.Readdir(-1)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	sort.Slice(inf, func(i, j int) bool { return inf[i].Name() < inf[j].Name() })
+	inf = inf[sort.Search(len(inf), func(i int) bool { return inf[i].Name() > after }):]
+	if len(inf) == 0 {
+		return nil, io.EOF
+	}
+	if len(inf) > n {
+		inf = inf[:n]
+	}
+	return inf, nil
+}
+
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case os.IsNotExist(err):
+		http.Error(w, "not found", http.StatusNotFound)
+	case os.IsPermission(err):
+		http.Error(w, "forbidden", http.StatusForbidden)
+	case errors.Is(err, syscall.ENOTEMPTY):
+		http.Error(w, "directory not empty", http.StatusConflict)
+	case errors.Is(err, os.ErrInvalid):
+		http.Error(w, "invalid path", http.StatusBadRequest)
+	case errors.Is(err, ErrQuotaExceeded):
+		http.Error(w, "quota exceeded", http.StatusInsufficientStorage)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// serveFile writes f using the metadata in fi, which must come from the
+// same open file so headers and body always match.
+func serveFile(w http.ResponseWriter, r *http.Request, name string, f http.File, fi os.FileInfo) {
+	meta, _ := fi.Sys().(*FileMeta)
+	if meta == nil {
+		meta = &FileMeta{}
+	}
+	hdr := w.Header()
+	if meta.ETag != "" {
+		hdr.Set("ETag", meta.ETag)
+	}
+	if !checkConditions(w, r, meta.ETag, fi.ModTime()) {
+		return
+	}
+
+	contentType := meta.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(name))
+	}
+	if contentType != "" {
+		hdr.Set("Content-Type", contentType)
+	}
+	if meta.ContentEncoding != "" {
+		hdr.Set("Content-Encoding", meta.ContentEncoding)
+	}
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+// checkConditions evaluates If-Match, If-Unmodified-Since, If-None-Match and
+// If-Modified-Since for a GET or HEAD request. It writes a response and returns false if the request
+// should not be served.
+func checkConditions(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) bool {
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !etagMatch(im, etag, false) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return false
+		}
+	} else if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && !modTime.IsZero() {
+		t, err := http.ParseTime(ius)
+		if err == nil && modTime.Truncate(time.Second).After(t) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return false
+		}
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if etagMatch(inm, etag, true) {
+			writeNotModified(w)
+			return false
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modTime.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err == nil && !modTime.Truncate(time.Second).After(t) {
+			writeNotModified(w)
+			return false
+		}
+	}
+	return true
+}
+
+func writeNotModified(w http.ResponseWriter) {
+	hdr := w.Header()
+	hdr.Del("Content-Type")
+	hdr.Del("Content-Length")
+	hdr.Del("Content-Encoding")
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// etagMatch reports if etag is in the comma separated list of tags in header.
+// Weak tags only match when weak comparison is requested.
+func etagMatch(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return etag != ""
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if etag != "" && tag == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package boltfs
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// noReadFS fails the test if any file contents are read or seeked.
+type noReadFS struct {
+	FileSystem
+	t *testing.T
+}
+type noReadFile struct {
+	http.File
+	t *testing.T
+}
+
+func (fs noReadFS) Open(name string) (http.File, error) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return noReadFile{f, fs.t}, nil
+}
+func (f noReadFile) Read([]byte) (int, error) {
+	f.t.Error("unexpected read")
+	return 0, io.ErrUnexpectedEOF
+}
+func (f noReadFile) Seek(int64, int) (int64, error) {
+	f.t.Error("unexpected seek")
+	return 0, io.ErrUnexpectedEOF
+}
+
+func TestHandler(t *testing.T) {
+	Convey("When serving files over HTTP", t, func() {
+		os.Remove("handler_test.db")
+		defer os.Remove("handler_test.db")
+		db, err := bolt.Open("handler_test.db", 0644, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fs, err := NewFileSystem(NewBoltDB(db), NewBucketPath([]byte("test")))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wc, err := fs.CreateWithOptions("data/foo.bin", &CreateOptions{ContentType: "application/json", ContentEncoding: "gzip"})
+		So(err, ShouldBeNil)
+		io.WriteString(wc, "hello world")
+		So(wc.Close(), ShouldBeNil)
+
+		h := NewHandler(fs, nil)
+		get := func(name string, hdr map[string]string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest("GET", name, nil)
+			for k, v := range hdr {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			return rec
+		}
+
+		Convey("Should serve stored content type, encoding and etag", func() {
+			rec := get("/data/foo.bin", nil)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/json")
+			So(rec.Header().Get("Content-Encoding"), ShouldEqual, "gzip")
+			So(rec.Header().Get("ETag"), ShouldStartWith, `"`)
+			So(rec.Body.String(), ShouldEqual, "hello world")
+		})
+		Convey("Should answer conditional requests", func() {
+			etag := get("/data/foo.bin", nil).Header().Get("ETag")
+
+			rec := get("/data/foo.bin", map[string]string{"If-None-Match": etag})
+			So(rec.Code, ShouldEqual, http.StatusNotModified)
+			So(rec.Body.Len(), ShouldEqual, 0)
+
+			rec = get("/data/foo.bin", map[string]string{"If-Match": `"nope"`})
+			So(rec.Code, ShouldEqual, http.StatusPreconditionFailed)
+
+			rec = get("/data/foo.bin", map[string]string{"If-Match": etag, "Range": "bytes=0-4"})
+			So(rec.Code, ShouldEqual, http.StatusPartialContent)
+			So(rec.Body.String(), ShouldEqual, "hello")
+		})
+		Convey("Should answer conditional requests without reading blocks", func() {
+			etag := get("/data/foo.bin", nil).Header().Get("ETag")
+			h := NewHandler(noReadFS{fs, t}, nil)
+			for _, hdr := range []map[string]string{{"If-None-Match": etag}, {"If-Match": `"nope"`}} {
+				req := httptest.NewRequest("GET", "/data/foo.bin", nil)
+				for k, v := range hdr {
+					req.Header.Set(k, v)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				So(rec.Code, ShouldBeIn, http.StatusNotModified, http.StatusPreconditionFailed)
+			}
+		})
+		Convey("Should change the etag when the file is replaced", func() {
+			etag := get("/data/foo.bin", nil).Header().Get("ETag")
+			wc, _ := fs.Create("data/foo.bin")
+			io.WriteString(wc, "goodbye")
+			wc.Close()
+			So(get("/data/foo.bin", nil).Header().Get("ETag"), ShouldNotEqual, etag)
+		})
+		Convey("Should return 404 for missing files", func() {
+			So(get("/data/missing", nil).Code, ShouldEqual, http.StatusNotFound)
+			_, err := fs.Stat("data/missing")
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+		Convey("Should return 400 for invalid paths", func() {
+			So(get("/data/bad%00name", nil).Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Should upload, list and delete files", func() {
+			h := NewHandler(fs, &HandlerOptions{MaxUploadSize: 16})
+			do := func(method, name, body string, hdr map[string]string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(method, name, strings.NewReader(body))
+				for k, v := range hdr {
+					req.Header.Set(k, v)
+				}
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				return rec
+			}
+
+			rec := do("PUT", "/up/new.txt", "uploaded", map[string]string{"Content-Type": "text/x-test"})
+			So(rec.Code, ShouldEqual, http.StatusCreated)
+			So(rec.Header().Get("ETag"), ShouldNotBeEmpty)
+			rec = do("GET", "/up/new.txt", "", nil)
+			So(rec.Body.String(), ShouldEqual, "uploaded")
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "text/x-test")
+
+			rec = do("POST", "/up/new.txt", "replaced", nil)
+			So(rec.Code, ShouldEqual, http.StatusNoContent)
+
+			rec = do("PUT", "/up/big.txt", strings.Repeat("x", 17), nil)
+			So(rec.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			_, err := fs.Stat("up/big.txt")
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			rec = do("GET", "/up/", "", map[string]string{"Accept": "application/json"})
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/json")
+			var entries []DirEntry
+			So(json.Unmarshal(rec.Body.Bytes(), &entries), ShouldBeNil)
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0].Name, ShouldEqual, "new.txt")
+			So(entries[0].Size, ShouldEqual, 8)
+
+			rec = do("GET", "/up/?limit=1&after=new.txt", "", map[string]string{"Accept": "application/json"})
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(json.Unmarshal(rec.Body.Bytes(), &entries), ShouldBeNil)
+			So(entries, ShouldBeEmpty)
+			So(do("GET", "/up/?limit=x", "", map[string]string{"Accept": "application/json"}).Code, ShouldEqual, http.StatusBadRequest)
+
+			m := NewMountFS()
+			So(m.Mount("/a", fs), ShouldBeNil)
+			So(m.Mount("/b", fs), ShouldBeNil)
+			rec = httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/?limit=1&after=a", nil)
+			req.Header.Set("Accept", "application/json")
+			NewHandler(m, nil).ServeHTTP(rec, req)
+			So(json.Unmarshal(rec.Body.Bytes(), &entries), ShouldBeNil)
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0].Name, ShouldEqual, "b")
+
+			So(do("DELETE", "/up", "", nil).Code, ShouldEqual, http.StatusConflict)
+			So(do("DELETE", "/up/new.txt", "", nil).Code, ShouldEqual, http.StatusNoContent)
+			So(do("GET", "/up/new.txt", "", nil).Code, ShouldEqual, http.StatusNotFound)
+			So(do("DELETE", "/up", "", nil).Code, ShouldEqual, http.StatusNoContent)
+		})
+		Convey("Should enforce read only mode and authorization", func() {
+			h := NewHandler(fs, &HandlerOptions{
+				ReadOnly: true,
+				Authorize: func(r *http.Request, name string) error {
+					if name == "/data/secret" {
+						return errors.New("denied")
+					}
+					return nil
+				},
+			})
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("PUT", "/data/x", strings.NewReader("x")))
+			So(rec.Code, ShouldEqual, http.StatusMethodNotAllowed)
+			rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/data/secret", nil))
+			So(rec.Code, ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
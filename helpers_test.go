@@ -0,0 +1,59 @@
+package boltfs
+
+import (
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// openTestDB opens a new <name>_test.db. The returned function closes and
+// removes it.
+func openTestDB(t *testing.T, name string) (*bolt.DB, func()) {
+	file := name + "_test.db"
+	os.Remove(file)
+	db, err := bolt.Open(file, 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.Remove(file)
+	}
+}
+
+// openTestFS opens a FileSystem with opts in the "test" bucket of a new
+// <name>_test.db.
+func openTestFS(t *testing.T, name string, opts *Options) (*bolt.DB, Store, func()) {
+	db, done := openTestDB(t, name)
+	fs, err := NewFileSystemWithOptions(NewBoltDB(db), NewBucketPath([]byte("test")), opts)
+	if err != nil {
+		done()
+		t.Fatal(err)
+	}
+	return db, fs, done
+}
+
+// writeFile replaces name with data.
+func writeFile(fs interface {
+	Create(string) (io.WriteCloser, error)
+}, name, data string) {
+	wc, err := fs.Create(name)
+	So(err, ShouldBeNil)
+	_, err = io.WriteString(wc, data)
+	So(err, ShouldBeNil)
+	So(wc.Close(), ShouldBeNil)
+}
+
+// readFile returns the contents of name.
+func readFile(hfs http.FileSystem, name string) string {
+	f, err := hfs.Open(name)
+	So(err, ShouldBeNil)
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	So(err, ShouldBeNil)
+	return string(data)
+}
@@ -0,0 +1,302 @@
+package boltfs
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gopkg.in/vmihailenco/msgpack.v2"
+	"net/http"
+	"os"
+	"time"
+)
+
+// historyPrefix starts the key of the bucket holding the old versions of a
+// file, next to the file in its directory.
+const historyPrefix = "\x00v:"
+
+// ErrNoVersion is returned for an unknown version id.
+var ErrNoVersion = errors.New("no such version")
+
+// Retention decides which versions of a file are kept when it is
+// overwritten. A version is kept if it is one of the newest Versions, or if
+// it was modified within MaxAge. Kept versions count towards the Usage and
+// quotas of the directory of the file, and are deleted along with it.
+type Retention struct {
+	Versions int
+	MaxAge   time.Duration
+}
+
+// FileVersion is an earlier version of a file.
+type FileVersion struct {
+	ID string
+	os.FileInfo
+}
+
+func historyKey(key []byte) []byte {
+	return append([]byte(historyPrefix), key...)
+}
+
+func isHistoryKey(k []byte) bool {
+	return len(k) > len(historyPrefix) && string(k[:len(historyPrefix)]) == historyPrefix
+}
+
+// inodeVersionKey returns the history key of the version stored in inode.
+// Inode keys are little endian, so they are reversed to list versions in
+// the order they were written.
+func inodeVersionKey(inode BucketPath) []byte {
+	id := inode[len(inode)-1]
+	key := make([]byte, len(id))
+	for i := range id {
+		key[i] = id[len(id)-1-i]
+	}
+	return key
+}
+
+// keepVersion moves old, the stat being replaced at key of bk, into the
+// history of the file, or deletes its inode if keep retains nothing. It
+// returns the change in space taken by the history.
+func keepVersion(tx Transaction, bk Bucket, key []byte, old *fileStat, keep Retention) (Usage, error) {
+	if keep == (Retention{}) || len(old.Inode) == 0 {
+		deleteInode(tx, old.Inode)
+		return Usage{}, nil
+	}
+	hb, err := bk.CreateBucketIfNotExists(historyKey(key))
+	if err != nil {
+		return Usage{}, err
+	}
+	data, err := msgpack.Marshal(old)
+	if err != nil {
+		return Usage{}, err
+	}
+	err = hb.Put(inodeVersionKey(old.Inode), data)
+	if err != nil {
+		return Usage{}, err
+	}
+	d, err := pruneVersions(tx, hb, keep, time.Now())
+	d.add(retainedUsage(old))
+	return d, err
+}
+
+// pruneVersions deletes the versions of hb that keep no longer retains,
+// returning the change in space taken by hb.
+func pruneVersions(tx Transaction, hb Bucket, keep Retention, now time.Time) (Usage, error) {
+	type version struct {
+		key  []byte
+		stat fileStat
+	}
+	var versions []version
+	var d Usage
+	c := hb.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var stat fileStat
+		err := msgpack.Unmarshal(v, &stat)
+		if err != nil {
+			return Usage{}, err
+		}
+		versions = append(versions, version{append([]byte{}, k...), stat})
+	}
+	for i, v := range versions {
+		if len(versions)-i <= keep.Versions || keep.MaxAge > 0 && now.Sub(v.stat.MTime) < keep.MaxAge {
+			continue
+		}
+		deleteInode(tx, v.stat.Inode)
+		err := hb.Delete(v.key)
+		if err != nil {
+			return d, err
+		}
+		d.add(retainedUsage(&v.stat).neg())
+	}
+	return d, nil
+}
+
+// historyUsage returns the space taken by the versions of the file key of
+// bk.
+func historyUsage(bk Bucket, key []byte) (Usage, error) {
+	var u Usage
+	hb := bk.Bucket(historyKey(key))
+	if hb == nil {
+		return u, nil
+	}
+	c := hb.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var stat fileStat
+		err := msgpack.Unmarshal(v, &stat)
+		if err != nil {
+			return u, err
+		}
+		u.add(retainedUsage(&stat))
+	}
+	return u, nil
+}
+
+// deleteVersions removes the inodes of every version in hb.
+func deleteVersions(tx Transaction, hb Bucket) error {
+	c := hb.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var stat fileStat
+		err := msgpack.Unmarshal(v, &stat)
+		if err != nil {
+			return err
+		}
+		deleteInode(tx, stat.Inode)
+	}
+	return nil
+}
+
+// deleteHistory removes the versions of the file key of bk.
+func deleteHistory(tx Transaction, bk Bucket, key []byte) error {
+	hb := bk.Bucket(historyKey(key))
+	if hb == nil {
+		return nil
+	}
+	err := deleteVersions(tx, hb)
+	if err != nil {
+		return err
+	}
+	return bk.DeleteBucket(historyKey(key))
+}
+
+// moveHistory moves the versions of the file srcKey of src to dstKey of
+// dst, adding them to any versions already kept there. Versions are keyed
+// by inode, so they stay in the order they were written.
+func moveHistory(tx Transaction, src Bucket, srcKey []byte, dst Bucket, dstKey []byte) error {
+	hb := src.Bucket(historyKey(srcKey))
+	if hb == nil {
+		return nil
+	}
+	moved, err := dst.CreateBucketIfNotExists(historyKey(dstKey))
+	if err != nil {
+		return err
+	}
+	err = copyBucket(hb, moved)
+	if err != nil {
+		return err
+	}
+	return src.DeleteBucket(historyKey(srcKey))
+}
+
+// walkVersions calls fn for every kept version below bk.
+func walkVersions(bk Bucket, fn func(stat *fileStat) error) error {
+	c := bk.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v != nil {
+			continue
+		}
+		if !isHistoryKey(k) {
+			if !reservedKey(k) {
+				err := walkVersions(bk.Bucket(k), fn)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		hc := bk.Bucket(k).Cursor()
+		for hk, hv := hc.First(); hk != nil; hk, hv = hc.Next() {
+			var stat fileStat
+			err := msgpack.Unmarshal(hv, &stat)
+			if err != nil {
+				return err
+			}
+			err = fn(&stat)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// history returns the history bucket of the file name, which may be nil.
+func (fs *boltFs) history(tx Transaction, op, name string) (Bucket, error) {
+	p, err := fs.fsPath(name)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	_, err = fs.statFile(tx, op, name)
+	if err != nil {
+		return nil, err
+	}
+	return BucketPath(p[:len(p)-1]).BucketFrom(tx).Bucket(historyKey(p[len(p)-1])), nil
+}
+
+// version returns the key and stat of version id of the file name.
+func (fs *boltFs) version(tx Transaction, op, name, id string) (Bucket, []byte, fileStat, error) {
+	var stat fileStat
+	hb, err := fs.history(tx, op, name)
+	if err != nil {
+		return nil, nil, stat, err
+	}
+	key, err := hex.DecodeString(id)
+	if err != nil || hb == nil || hb.Get(key) == nil {
+		return nil, nil, stat, fmt.Errorf("%s %s: version %s: %w", op, name, id, ErrNoVersion)
+	}
+	return hb, key, stat, msgpack.Unmarshal(hb.Get(key), &stat)
+}
+
+// Versions lists the kept versions of the file name, oldest first.
+func (fs *boltFs) Versions(name string) ([]FileVersion, error) {
+	var versions []FileVersion
+	err := fs.db.View(func(tx Transaction) error {
+		hb, err := fs.history(tx, "versions", name)
+		if err != nil || hb == nil {
+			return err
+		}
+		c := hb.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var stat fileStat
+			err := msgpack.Unmarshal(v, &stat)
+			if err != nil {
+				return err
+			}
+			stat.xattrs = readXattrs(tx, stat.Inode)
+			versions = append(versions, FileVersion{ID: hex.EncodeToString(k), FileInfo: stat})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// OpenVersion opens version id of the file name for reading.
+func (fs *boltFs) OpenVersion(name, id string) (http.File, error) {
+	tx, err := fs.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	_, _, stat, err := fs.version(tx, "open", name, id)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	rf := &readableFile{tx: tx, stat: stat}
+	rf.openData()
+	return rf, nil
+}
+
+// Restore makes version id the current content of the file name. The
+// content it replaces is kept as a version, like any other overwrite.
+func (fs *boltFs) Restore(name, id string) error {
+	p, names, err := fs.fsNames(name)
+	if err != nil {
+		return &os.PathError{Op: "restore", Path: name, Err: err}
+	}
+	return fs.db.Update(func(tx Transaction) error {
+		hb, key, stat, err := fs.version(tx, "restore", name, id)
+		if err != nil {
+			return err
+		}
+		err = hb.Delete(key)
+		if err != nil {
+			return err
+		}
+		err = addUsage(tx, p[:len(p)-1], retainedUsage(&stat).neg())
+		if err != nil {
+			return err
+		}
+		stat.Filename = names[len(names)-1]
+		return putStat(tx, p, names, &stat, nil, fs.opts.Retention)
+	})
+}
@@ -0,0 +1,135 @@
+package boltfs
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	Convey("When keeping file versions", t, func() {
+		db, fs, done := openTestFS(t, "history", &Options{Retention: Retention{Versions: 2}})
+		defer done()
+		read := func(f io.ReadCloser, err error) string {
+			So(err, ShouldBeNil)
+			defer f.Close()
+			data, err := ioutil.ReadAll(f)
+			So(err, ShouldBeNil)
+			return string(data)
+		}
+		contents := func(name string) []string {
+			versions, err := fs.Versions(name)
+			So(err, ShouldBeNil)
+			var data []string
+			for _, v := range versions {
+				data = append(data, read(fs.OpenVersion(name, v.ID)))
+			}
+			return data
+		}
+		for _, data := range []string{"one", "two", "three", "four"} {
+			writeFile(fs, "dir/file", data)
+		}
+
+		Convey("Should keep the newest versions", func() {
+			So(readFile(fs, "dir/file"), ShouldEqual, "four")
+			So(contents("dir/file"), ShouldResemble, []string{"two", "three"})
+			versions, err := fs.Versions("dir/file")
+			So(err, ShouldBeNil)
+			So(versions[0].Name(), ShouldEqual, "file")
+			So(versions[0].Size(), ShouldEqual, 3)
+
+			_, err = fs.OpenVersion("dir/file", "0000")
+			So(errors.Is(err, ErrNoVersion), ShouldBeTrue)
+			_, err = fs.Versions("dir")
+			So(err, ShouldNotBeNil)
+
+			report, err := fs.Fsck(true)
+			So(err, ShouldBeNil)
+			So(report.Orphans, ShouldEqual, 0)
+			So(report.Problems, ShouldBeEmpty)
+			So(contents("dir/file"), ShouldResemble, []string{"two", "three"})
+		})
+		Convey("Should restore a version", func() {
+			versions, _ := fs.Versions("dir/file")
+			So(fs.Restore("dir/file", versions[0].ID), ShouldBeNil)
+			So(readFile(fs, "dir/file"), ShouldEqual, "two")
+			So(contents("dir/file"), ShouldResemble, []string{"three", "four"})
+			So(errors.Is(fs.Restore("dir/file", versions[0].ID), ErrNoVersion), ShouldBeTrue)
+		})
+		Convey("Should move versions with the file", func() {
+			So(fs.Rename("dir/file", "moved"), ShouldBeNil)
+			So(contents("moved"), ShouldResemble, []string{"two", "three"})
+			So(fs.Rename("dir", "other"), ShouldBeNil)
+
+			writeFile(fs, "dir2/file", "a")
+			writeFile(fs, "dir2/file", "b")
+			So(fs.Rename("dir2", "dir3"), ShouldBeNil)
+			So(contents("dir3/file"), ShouldResemble, []string{"a"})
+		})
+		Convey("Should keep a file replaced by a rename as a version", func() {
+			writeFile(fs, "other", "a")
+			writeFile(fs, "other", "b")
+			So(fs.Rename("other", "dir/file"), ShouldBeNil)
+			So(readFile(fs, "dir/file"), ShouldEqual, "b")
+			So(contents("dir/file"), ShouldResemble, []string{"four", "a"})
+
+			report, err := fs.Fsck(false)
+			So(err, ShouldBeNil)
+			So(report.Orphans, ShouldEqual, 0)
+		})
+		Convey("Should delete versions with the file", func() {
+			So(fs.Remove("dir/file"), ShouldBeNil)
+			writeFile(fs, "dir/file", "new")
+			So(contents("dir/file"), ShouldBeEmpty)
+			writeFile(fs, "dir/file", "newer")
+			So(fs.RemoveAll("dir"), ShouldBeNil)
+
+			report, err := fs.Fsck(false)
+			So(err, ShouldBeNil)
+			So(report.Orphans, ShouldEqual, 0)
+		})
+		Convey("Should charge kept versions to their directory", func() {
+			u, err := fs.Usage("dir")
+			So(err, ShouldBeNil)
+			So(*u, ShouldResemble, Usage{Bytes: 12, Files: 1, Blocks: 3})
+
+			counted, err := NewFileSystemWithOptions(NewBoltDB(db), NewBucketPath([]byte("counted")), &Options{Counters: true, Retention: Retention{Versions: 2}})
+			So(err, ShouldBeNil)
+			usage := func(name string) Usage {
+				u, err := counted.Usage(name)
+				So(err, ShouldBeNil)
+				return *u
+			}
+			for _, data := range []string{"one", "two", "three", "four"} {
+				wc, err := counted.Create("dir/file")
+				So(err, ShouldBeNil)
+				io.WriteString(wc, data)
+				So(wc.Close(), ShouldBeNil)
+			}
+			So(usage("dir"), ShouldResemble, Usage{Bytes: 12, Files: 1, Blocks: 3})
+			So(counted.Rename("dir/file", "file"), ShouldBeNil)
+			So(usage("dir"), ShouldResemble, Usage{})
+			So(usage("/"), ShouldResemble, Usage{Bytes: 12, Files: 1, Directories: 1, Blocks: 3})
+			versions, err := counted.Versions("file")
+			So(err, ShouldBeNil)
+			So(counted.Restore("file", versions[0].ID), ShouldBeNil)
+			So(usage("/"), ShouldResemble, Usage{Bytes: 12, Files: 1, Directories: 1, Blocks: 3})
+			So(counted.Remove("file"), ShouldBeNil)
+			So(usage("/"), ShouldResemble, Usage{Directories: 1})
+		})
+		Convey("Should keep recent versions by age", func() {
+			aged, err := NewFileSystemWithOptions(NewBoltDB(db), NewBucketPath([]byte("aged")), &Options{Retention: Retention{MaxAge: time.Hour}})
+			So(err, ShouldBeNil)
+			for _, mtime := range []time.Time{time.Now().Add(-2 * time.Hour), time.Now(), time.Now()} {
+				wc, _ := aged.CreateWithOptions("f", &CreateOptions{ModTime: mtime})
+				So(wc.Close(), ShouldBeNil)
+			}
+			versions, err := aged.Versions("f")
+			So(err, ShouldBeNil)
+			So(len(versions), ShouldEqual, 1)
+		})
+	})
+}
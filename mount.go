@@ -0,0 +1,342 @@
+package boltfs
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MountFS serves several FileSystems under one root by path prefix. Paths
+// are routed to the FileSystem mounted at the longest matching prefix, and
+// directories leading to mount points are synthesized so they can be
+// listed, so a single http.FileServer can serve every mount.
+//
+// Path based operations are routed to the owning mount. MountFS is a plain
+// FileSystem: uploads, archives and Fsck work on a whole store, so they
+// are used on the mounted Stores directly.
+type MountFS struct {
+	mx     sync.RWMutex
+	mounts map[string]FileSystem
+}
+
+// NewMountFS returns an empty MountFS.
+func NewMountFS() *MountFS {
+	return &MountFS{mounts: make(map[string]FileSystem)}
+}
+
+func cleanPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// Mount makes fs available below prefix, which may be "/".
+func (m *MountFS) Mount(prefix string, fs FileSystem) error {
+	prefix = cleanPath(prefix)
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if _, ok := m.mounts[prefix]; ok {
+		return &os.PathError{Op: "mount", Path: prefix, Err: os.ErrExist}
+	}
+	m.mounts[prefix] = fs
+	return nil
+}
+
+// Unmount removes the FileSystem mounted at prefix.
+func (m *MountFS) Unmount(prefix string) error {
+	prefix = cleanPath(prefix)
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if _, ok := m.mounts[prefix]; !ok {
+		return &os.PathError{Op: "unmount", Path: prefix, Err: os.ErrNotExist}
+	}
+	delete(m.mounts, prefix)
+	return nil
+}
+
+// resolve returns the FileSystem owning name and the path within it.
+func (m *MountFS) resolve(name string) (FileSystem, string, bool) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	for prefix := name; ; prefix = path.Dir(prefix) {
+		if fs, ok := m.mounts[prefix]; ok {
+			return fs, cleanPath(strings.TrimPrefix(name, prefix)), true
+		}
+		if prefix == "/" {
+			return nil, "", false
+		}
+	}
+}
+
+// children returns the names of the directories directly below name that
+// lead to mount points.
+func (m *MountFS) children(name string) []string {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	dir := name
+	if dir != "/" {
+		dir += "/"
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for prefix := range m.mounts {
+		if prefix == name || !strings.HasPrefix(prefix, dir) {
+			continue
+		}
+		child := strings.SplitN(prefix[len(dir):], "/", 2)[0]
+		if !seen[child] {
+			seen[child] = true
+			names = append(names, child)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *MountFS) Open(name string) (http.File, error) {
+	name = cleanPath(name)
+	children := m.children(name)
+	fs, rel, ok := m.resolve(name)
+	if !ok {
+		if len(children) == 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		return &mountDir{name: name, children: children}, nil
+	}
+	f, err := fs.Open(rel)
+	if err != nil {
+		if len(children) == 0 {
+			return nil, err
+		}
+		return &mountDir{name: name, children: children}, nil
+	}
+	if len(children) == 0 && rel != "/" {
+		return f, nil
+	}
+	fi, err := f.Stat()
+	if err != nil || !fi.IsDir() {
+		return f, err
+	}
+	return &mountDir{name: name, children: children, f: f}, nil
+}
+
+func (m *MountFS) Stat(name string) (os.FileInfo, error) {
+	name = cleanPath(name)
+	fs, rel, ok := m.resolve(name)
+	if ok {
+		fi, err := fs.Stat(rel)
+		if err == nil && rel == "/" {
+			return mountDirInfo(path.Base(name)), nil
+		}
+		if err == nil || len(m.children(name)) == 0 {
+			return fi, err
+		}
+	}
+	if len(m.children(name)) > 0 {
+		return mountDirInfo(path.Base(name)), nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+// route resolves name for an operation that changes a mount.
+func (m *MountFS) route(op, name string) (FileSystem, string, error) {
+	name = cleanPath(name)
+	fs, rel, ok := m.resolve(name)
+	if !ok {
+		if len(m.children(name)) > 0 {
+			return nil, "", &os.PathError{Op: op, Path: name, Err: os.ErrExist}
+		}
+		return nil, "", &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	}
+	return fs, rel, nil
+}
+
+func (m *MountFS) Create(name string) (io.WriteCloser, error) {
+	return m.CreateWithOptions(name, nil)
+}
+
+func (m *MountFS) CreateWithOptions(name string, opts *CreateOptions) (io.WriteCloser, error) {
+	fs, rel, err := m.route("create", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.CreateWithOptions(rel, opts)
+}
+
+func (m *MountFS) Mkdir(name string) error {
+	fs, rel, err := m.route("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if rel == "/" {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	return fs.Mkdir(rel)
+}
+
+// Remove and RemoveAll refuse to remove mount points, but RemoveAll does
+// not descend into other mounts below name.
+func (m *MountFS) Remove(name string) error {
+	fs, rel, err := m.route("remove", name)
+	if err != nil {
+		return err
+	}
+	if rel == "/" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+	return fs.Remove(rel)
+}
+
+func (m *MountFS) RemoveAll(name string) error {
+	fs, rel, err := m.route("remove", name)
+	if err != nil {
+		return err
+	}
+	if rel == "/" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+	return fs.RemoveAll(rel)
+}
+
+// Rename only moves files within a single mount.
+func (m *MountFS) Rename(oldName, newName string) error {
+	oldFs, oldRel, err := m.route("rename", oldName)
+	if err != nil {
+		return err
+	}
+	newFs, newRel, err := m.route("rename", newName)
+	if err != nil {
+		return err
+	}
+	if oldFs != newFs {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: syscall.EXDEV}
+	}
+	return oldFs.Rename(oldRel, newRel)
+}
+
+func (m *MountFS) SetXattr(name, key string, value []byte) error {
+	fs, rel, err := m.route("setxattr", name)
+	if err != nil {
+		return err
+	}
+	return fs.SetXattr(rel, key, value)
+}
+
+func (m *MountFS) GetXattr(name, key string) ([]byte, error) {
+	fs, rel, err := m.route("getxattr", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.GetXattr(rel, key)
+}
+
+func (m *MountFS) ListXattr(name string) ([]string, error) {
+	fs, rel, err := m.route("listxattr", name)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ListXattr(rel)
+}
+
+func (m *MountFS) RemoveXattr(name, key string) error {
+	fs, rel, err := m.route("removexattr", name)
+	if err != nil {
+		return err
+	}
+	return fs.RemoveXattr(rel, key)
+}
+
+type mountDirInfo string
+
+func (d mountDirInfo) Name() string       { return string(d) }
+func (d mountDirInfo) Size() int64        { return 0 }
+func (d mountDirInfo) Mode() os.FileMode  { return os.ModeDir | 0777 }
+func (d mountDirInfo) ModTime() time.Time { return time.Time{} }
+func (d mountDirInfo) IsDir() bool        { return true }
+func (d mountDirInfo) Sys() interface{}   { return nil }
+
+// mountDir is a directory containing mount points, either synthesized or
+// merged with a directory of a mounted FileSystem.
+type mountDir struct {
+	name     string
+	children []string
+	f        http.File
+	entries  []os.FileInfo
+	loaded   bool
+}
+
+func (d *mountDir) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("is a directory")
+}
+
+func (d *mountDir) Seek(offset int64, whence int) (int64, error) {
+	return 0, fmt.Errorf("is a directory")
+}
+
+func (d *mountDir) Stat() (os.FileInfo, error) {
+	return mountDirInfo(path.Base(d.name)), nil
+}
+
+func (d *mountDir) load() error {
+	d.loaded = true
+	mounted := make(map[string]bool, len(d.children))
+	for _, child := range d.children {
+		mounted[child] = true
+		d.entries = append(d.entries, mountDirInfo(child))
+	}
+	if d.f != nil {
+		inf, err := d.f.Readdir(-1)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		for _, fi := range inf {
+			if !mounted[fi.Name()] {
+				d.entries = append(d.entries, fi)
+			}
+		}
+	}
+	sort.Slice(d.entries, func(i, j int) bool { return d.entries[i].Name() < d.entries[j].Name() })
+	return nil
+}
+
+// Readdir follows os.File: with count > 0 it returns io.EOF once every
+// entry has been returned.
+func (d *mountDir) Readdir(count int) ([]os.FileInfo, error) {
+	var err error
+	if !d.loaded {
+		err = d.load()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var inf []os.FileInfo
+	inf, d.entries, err = nextEntries(d.entries, count)
+	return inf, err
+}
+
+// nextEntries returns the next count entries of a directory listing and
+// those left over, following the Readdir semantics of os.File.
+func nextEntries(entries []os.FileInfo, count int) ([]os.FileInfo, []os.FileInfo, error) {
+	if count <= 0 {
+		return entries, nil, nil
+	}
+	if len(entries) == 0 {
+		return nil, nil, io.EOF
+	}
+	if count > len(entries) {
+		count = len(entries)
+	}
+	return entries[:count], entries[count:], nil
+}
+
+func (d *mountDir) Close() error {
+	if d.f != nil {
+		return d.f.Close()
+	}
+	return nil
+}
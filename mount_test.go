@@ -0,0 +1,107 @@
+package boltfs
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestMountFS(t *testing.T) {
+	Convey("When mounting several filesystems", t, func() {
+		db, done := openTestDB(t, "mount")
+		defer done()
+		newFS := func(name string) FileSystem {
+			fs, err := NewFileSystem(NewBoltDB(db), NewBucketPath([]byte(name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return fs
+		}
+		names := func(m *MountFS, dir string) []string {
+			f, err := m.Open(dir)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			inf, err := f.Readdir(-1)
+			So(err, ShouldBeNil)
+			var names []string
+			for _, fi := range inf {
+				names = append(names, fi.Name())
+			}
+			return names
+		}
+		root, assets, logs := newFS("root"), newFS("assets"), newFS("logs")
+		writeFile(root, "index.html", "root")
+		writeFile(root, "static/old.css", "shadowed")
+		writeFile(assets, "site.css", "css")
+		writeFile(logs, "today", "log")
+
+		m := NewMountFS()
+		So(m.Mount("/", root), ShouldBeNil)
+		So(m.Mount("/static", assets), ShouldBeNil)
+		So(m.Mount("/var/log", logs), ShouldBeNil)
+		So(os.IsExist(m.Mount("/static/", assets)), ShouldBeTrue)
+
+		Convey("Should route reads by prefix", func() {
+			rec := httptest.NewRecorder()
+			http.FileServer(m).ServeHTTP(rec, httptest.NewRequest("GET", "/static/site.css", nil))
+			So(rec.Body.String(), ShouldEqual, "css")
+
+			f, err := m.Open("/var/log/today")
+			So(err, ShouldBeNil)
+			data, _ := ioutil.ReadAll(f)
+			f.Close()
+			So(string(data), ShouldEqual, "log")
+
+			_, err = m.Open("/static/old.css")
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+		Convey("Should list mount points and synthesized directories", func() {
+			So(names(m, "/"), ShouldResemble, []string{"index.html", "static", "var"})
+			So(names(m, "/var"), ShouldResemble, []string{"log"})
+			So(names(m, "/static"), ShouldResemble, []string{"site.css"})
+
+			fi, err := m.Stat("/var")
+			So(err, ShouldBeNil)
+			So(fi.IsDir(), ShouldBeTrue)
+			fi, err = m.Stat("/var/log")
+			So(err, ShouldBeNil)
+			So(fi.Name(), ShouldEqual, "log")
+		})
+		Convey("Should page through merged directories", func() {
+			f, err := m.Open("/")
+			So(err, ShouldBeNil)
+			defer f.Close()
+			inf, err := f.Readdir(2)
+			So(err, ShouldBeNil)
+			So(inf, ShouldHaveLength, 2)
+			inf, err = f.Readdir(2)
+			So(err, ShouldBeNil)
+			So(inf, ShouldHaveLength, 1)
+			_, err = f.Readdir(2)
+			So(err, ShouldEqual, io.EOF)
+		})
+		Convey("Should route writes to the owning mount", func() {
+			writeFile(m, "/var/log/tomorrow", "later")
+			_, err := logs.Stat("tomorrow")
+			So(err, ShouldBeNil)
+
+			So(m.Rename("/var/log/tomorrow", "/var/log/next"), ShouldBeNil)
+			err = m.Rename("/var/log/next", "/static/next")
+			So(errors.Is(err, syscall.EXDEV), ShouldBeTrue)
+			So(os.IsPermission(m.Remove("/static")), ShouldBeTrue)
+			So(os.IsExist(m.Mkdir("/var/log")), ShouldBeTrue)
+		})
+		Convey("Should stop routing after unmounting", func() {
+			So(m.Unmount("/static"), ShouldBeNil)
+			rec := httptest.NewRecorder()
+			http.FileServer(m).ServeHTTP(rec, httptest.NewRequest("GET", "/static/old.css", nil))
+			So(rec.Body.String(), ShouldEqual, "shadowed")
+		})
+	})
+}
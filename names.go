@@ -0,0 +1,154 @@
+package boltfs
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+	"gopkg.in/vmihailenco/msgpack.v2"
+	"os"
+	"strings"
+	"syscall"
+)
+
+const optionsKey = "boltfs_options"
+
+// dirNameKey holds the display name of a directory within its bucket, when
+// it differs from the bucket key.
+const dirNameKey = "\x00name"
+
+// ErrNameConflict is returned when a file is written with a name that
+// differs from the name of the existing file it would replace, such as
+// "README" replacing "readme" in a case-insensitive FileSystem.
+var ErrNameConflict = fmt.Errorf("name conflicts with an existing name: %w", os.ErrExist)
+
+// Options control how a FileSystem matches names and what it keeps track
+// of. They are saved when the FileSystem is created and used whenever it is
+// opened without options. NormalizeNFC and CaseInsensitive cannot be
+// changed afterwards, and Counters cannot be turned off once on; the other
+// options may differ every time the FileSystem is opened.
+type Options struct {
+	// NormalizeNFC matches names in Unicode normalization form C, so the
+	// composed and decomposed forms of a name refer to the same file.
+	NormalizeNFC bool
+
+	// CaseInsensitive matches names regardless of case.
+	CaseInsensitive bool
+
+	// Counters keeps the usage of every directory up to date as files
+	// change, so Usage and StatFS do not walk the tree. Turning it on for
+	// an existing FileSystem counts its tree once.
+	Counters bool
+
+	// Retention keeps old versions of files that are overwritten.
+	Retention Retention
+
+	// Trash moves removed files and directories to the trash, where they
+	// can be undeleted until they are purged. Until then their data counts
+	// towards the usage and quotas of the root of the FileSystem.
+	Trash bool
+}
+
+// loadOptions returns the options saved in bk, saving opts if bk is new or
+// they change what may be changed.
+func loadOptions(bk Bucket, opts *Options, created bool) (Options, error) {
+	var saved Options
+	if !created {
+		if data := bk.Get([]byte(optionsKey)); data != nil {
+			err := msgpack.Unmarshal(data, &saved)
+			if err != nil {
+				return saved, fmt.Errorf("could not read existing fs options: %w", err)
+			}
+		}
+		if opts == nil || *opts == saved {
+			return saved, nil
+		}
+		if opts.NormalizeNFC != saved.NormalizeNFC || opts.CaseInsensitive != saved.CaseInsensitive {
+			return saved, errors.New("existing fs options mismatch")
+		}
+		if saved.Counters && !opts.Counters {
+			return saved, errors.New("existing fs counters cannot be turned off")
+		}
+	}
+	if opts != nil {
+		saved = *opts
+	}
+	data, err := msgpack.Marshal(&saved)
+	if err != nil {
+		return saved, err
+	}
+	return saved, bk.Put([]byte(optionsKey), data)
+}
+
+// fold returns the key name is stored under.
+func (o Options) fold(name string) string {
+	if o.CaseInsensitive {
+		name = cases.Fold().String(name)
+	}
+	if o.NormalizeNFC {
+		name = norm.NFC.String(name)
+	}
+	return name
+}
+
+// fsNames returns the stat path for name along with the display names of
+// its elements below the root.
+func (fs *boltFs) fsNames(name string) (BucketPath, []string, error) {
+	parts, err := splitPath(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := make(BucketPath, len(fs.root), len(fs.root)+len(parts))
+	copy(p, fs.root)
+	for _, part := range parts {
+		p = append(p, []byte(fs.opts.fold(part)))
+	}
+	return p, parts, nil
+}
+
+// dirName returns the display name of the directory bk stored under key.
+func dirName(bk Bucket, key []byte) string {
+	if name := bk.Get([]byte(dirNameKey)); name != nil {
+		return string(name)
+	}
+	return string(key)
+}
+
+func setDirName(bk Bucket, key []byte, name string) error {
+	if name == string(key) {
+		return bk.Delete([]byte(dirNameKey))
+	}
+	return bk.Put([]byte(dirNameKey), []byte(name))
+}
+
+// mkdirs creates the directories of p, storing the display names given for
+// the last len(names) elements.
+func mkdirs(tx Transaction, p BucketPath, names []string) (Bucket, error) {
+	root := len(p) - len(names)
+	bk, err := p[:root].MkFrom(tx)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range p[root:] {
+		if child := bk.Bucket(key); child != nil {
+			bk = child
+			continue
+		}
+		if bk.Get(key) != nil {
+			return nil, &os.PathError{Op: "mkdir", Path: strings.Join(names[:i+1], "/"), Err: syscall.ENOTDIR}
+		}
+		bk, err = bk.CreateBucket(key)
+		if err != nil {
+			return nil, err
+		}
+		err = setDirName(bk, key, names[i])
+		if err != nil {
+			return nil, err
+		}
+		err = addUsage(tx, p[:root+i], Usage{Directories: 1})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bk, nil
+}
@@ -0,0 +1,87 @@
+package boltfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestNameOptions(t *testing.T) {
+	Convey("When matching names with options", t, func() {
+		opts := &Options{NormalizeNFC: true, CaseInsensitive: true}
+		db, fs, done := openTestFS(t, "names", opts)
+		defer done()
+		names := func(dir string) []string {
+			f, err := fs.Open(dir)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			inf, err := f.Readdir(-1)
+			So(err, ShouldBeNil)
+			var names []string
+			for _, fi := range inf {
+				names = append(names, fi.Name())
+			}
+			return names
+		}
+		So(fs.Mkdir("Docs"), ShouldBeNil)
+		writeFile(fs, "DOCS/Read Me.txt", "hello")
+		writeFile(fs, "Cafe\u0301", "decomposed")
+
+		Convey("Should find files by folded names", func() {
+			So(readFile(fs, "docs/read me.TXT"), ShouldEqual, "hello")
+			So(readFile(fs, "Caf\u00e9"), ShouldEqual, "decomposed")
+			fi, err := fs.Stat("docs")
+			So(err, ShouldBeNil)
+			So(fi.Name(), ShouldEqual, "Docs")
+		})
+		Convey("Should list display names", func() {
+			So(names("/"), ShouldResemble, []string{"Cafe\u0301", "Docs"})
+			So(names("docs"), ShouldResemble, []string{"Read Me.txt"})
+
+			writeFile(fs, "New/Sub/file", "x")
+			So(names("new"), ShouldResemble, []string{"Sub"})
+		})
+		Convey("Should detect conflicting names", func() {
+			_, err := fs.Create("docs/READ ME.txt")
+			So(errors.Is(err, ErrNameConflict), ShouldBeTrue)
+			So(errors.Is(err, os.ErrExist), ShouldBeTrue)
+			writeFile(fs, "Docs/Read Me.txt", "replaced")
+			So(readFile(fs, "docs/read me.txt"), ShouldEqual, "replaced")
+			So(os.IsExist(fs.Mkdir("docs")), ShouldBeTrue)
+		})
+		Convey("Should rename by changing case", func() {
+			So(fs.Rename("docs", "DOCS"), ShouldBeNil)
+			So(fs.Rename("DOCS/read me.txt", "docs/README.txt"), ShouldBeNil)
+			So(names("/"), ShouldResemble, []string{"Cafe\u0301", "DOCS"})
+			So(names("docs"), ShouldResemble, []string{"README.txt"})
+		})
+		Convey("Should export display names", func() {
+			var buf bytes.Buffer
+			So(fs.ExportTar(&buf, "/"), ShouldBeNil)
+			tr := tar.NewReader(&buf)
+			var entries []string
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				So(err, ShouldBeNil)
+				entries = append(entries, hdr.Name)
+			}
+			So(entries, ShouldResemble, []string{"Cafe\u0301", "Docs/", "Docs/Read Me.txt"})
+		})
+		Convey("Should keep the options of an existing filesystem", func() {
+			again, err := NewFileSystem(NewBoltDB(db), NewBucketPath([]byte("test")))
+			So(err, ShouldBeNil)
+			_, err = again.Stat("DOCS/READ ME.TXT")
+			So(err, ShouldBeNil)
+
+			_, err = NewFileSystemWithOptions(NewBoltDB(db), NewBucketPath([]byte("test")), &Options{})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,427 @@
+package boltfs
+
+import (
+	"gopkg.in/vmihailenco/msgpack.v2"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Mkdir creates a directory. The parent directory must already exist.
+func (fs *boltFs) Mkdir(name string) error {
+	p, names, err := fs.fsNames(name)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if fs.isRoot(p) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	return fs.db.Update(func(tx Transaction) error {
+		parent, err := fs.parentBucket(tx, p)
+		if err != nil {
+			return &os.PathError{Op: "mkdir", Path: name, Err: err}
+		}
+		key := p[len(p)-1]
+		if parent.Bucket(key) != nil || parent.Get(key) != nil {
+			return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+		}
+		bk, err := parent.CreateBucket(key)
+		if err != nil {
+			return err
+		}
+		err = addUsage(tx, p[:len(p)-1], Usage{Directories: 1})
+		if err != nil {
+			return err
+		}
+		return setDirName(bk, key, names[len(names)-1])
+	})
+}
+
+// parentBucket returns the bucket of the directory containing p, failing
+// with ENOTDIR if a file is in the way.
+func (fs *boltFs) parentBucket(tx Transaction, p BucketPath) (Bucket, error) {
+	bk := fs.root.BucketFrom(tx)
+	for _, key := range p[len(fs.root) : len(p)-1] {
+		if bk == nil {
+			break
+		}
+		child := bk.Bucket(key)
+		if child == nil && bk.Get(key) != nil {
+			return nil, syscall.ENOTDIR
+		}
+		bk = child
+	}
+	if bk == nil {
+		return nil, os.ErrNotExist
+	}
+	return bk, nil
+}
+
+// checkCreate returns an error if a file cannot be written at p: if p is a
+// directory, if one of its parents is a file, or if a file with a
+// different display name is stored there.
+func (fs *boltFs) checkCreate(op, name string, p BucketPath, display string) error {
+	return fs.db.View(func(tx Transaction) error {
+		parent, err := fs.parentBucket(tx, p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return &os.PathError{Op: op, Path: name, Err: err}
+		}
+		key := p[len(p)-1]
+		if parent.Bucket(key) != nil {
+			return &os.PathError{Op: op, Path: name, Err: syscall.EISDIR}
+		}
+		data := parent.Get(key)
+		if data == nil {
+			return nil
+		}
+		var stat fileStat
+		if msgpack.Unmarshal(data, &stat) == nil && stat.Filename != "" && stat.Filename != display {
+			return &os.PathError{Op: op, Path: name, Err: ErrNameConflict}
+		}
+		return nil
+	})
+}
+
+// Remove deletes a file or an empty directory.
+func (fs *boltFs) Remove(name string) error {
+	p, names, err := fs.fsNames(name)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if fs.isRoot(p) {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+	return fs.db.Update(func(tx Transaction) error {
+		_, stat, err := fs.lookup(tx, name)
+		if err != nil {
+			return &os.PathError{Op: "remove", Path: name, Err: err}
+		}
+		parent := BucketPath(p[:len(p)-1]).BucketFrom(tx)
+		key := p[len(p)-1]
+		if stat.Dir && !emptyDir(parent.Bucket(key)) {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+		removed, err := entryUsage(parent, key, &stat)
+		if err != nil {
+			return err
+		}
+		err = addUsage(tx, p[:len(p)-1], removed.neg())
+		if err != nil {
+			return err
+		}
+		return fs.discard(tx, parent, p, names, &stat)
+	})
+}
+
+// RemoveAll deletes name and everything under it. It is not an error if
+// name does not exist.
+func (fs *boltFs) RemoveAll(name string) error {
+	p, names, err := fs.fsNames(name)
+	if err != nil {
+		return &os.PathError{Op: "removeall", Path: name, Err: err}
+	}
+	if fs.isRoot(p) {
+		return &os.PathError{Op: "removeall", Path: name, Err: os.ErrPermission}
+	}
+	return fs.db.Update(func(tx Transaction) error {
+		_, stat, err := fs.lookup(tx, name)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return &os.PathError{Op: "removeall", Path: name, Err: err}
+		}
+		parent := BucketPath(p[:len(p)-1]).BucketFrom(tx)
+		key := p[len(p)-1]
+		removed, err := entryUsage(parent, key, &stat)
+		if err != nil {
+			return err
+		}
+		err = addUsage(tx, p[:len(p)-1], removed.neg())
+		if err != nil {
+			return err
+		}
+		return fs.discard(tx, parent, p, names, &stat)
+	})
+}
+
+// discard removes the file or directory at p from parent, moving it to the
+// trash if the FileSystem keeps one.
+func (fs *boltFs) discard(tx Transaction, parent Bucket, p BucketPath, names []string, stat *fileStat) error {
+	if fs.opts.Trash {
+		return fs.trash(tx, parent, p, names, stat)
+	}
+	key := p[len(p)-1]
+	if stat.Dir {
+		err := deleteTree(tx, parent.Bucket(key))
+		if err != nil {
+			return err
+		}
+		return parent.DeleteBucket(key)
+	}
+	deleteInode(tx, stat.Inode)
+	err := deleteHistory(tx, parent, key)
+	if err != nil {
+		return err
+	}
+	return parent.Delete(key)
+}
+
+// deleteTree removes the inodes of every file below bk.
+func deleteTree(tx Transaction, bk Bucket) error {
+	c := bk.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if isHistoryKey(k) {
+			err := deleteVersions(tx, bk.Bucket(k))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if reservedKey(k) {
+			continue
+		}
+		if v == nil {
+			err := deleteTree(tx, bk.Bucket(k))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		var stat fileStat
+		err := msgpack.Unmarshal(v, &stat)
+		if err != nil {
+			return err
+		}
+		deleteInode(tx, stat.Inode)
+	}
+	return nil
+}
+
+// Sub returns a FileSystem for the directory dir. It resolves every name
+// within dir, so neither absolute paths nor ".." can reach outside of it.
+// Inodes are shared with fs, and Fsck still checks the whole store.
+func (fs *boltFs) Sub(dir string) (Store, error) {
+	fi, err := fs.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &os.PathError{Op: "sub", Path: dir, Err: syscall.ENOTDIR}
+	}
+	root, err := fs.fsPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &boltFs{db: fs.db, path: fs.path, root: root, opts: fs.opts}, nil
+}
+
+// Rename moves a file or directory. An existing file at newName is replaced,
+// an existing directory only if it is empty and oldName is also a directory.
+// A replaced file is kept as a version or moved to the trash, if the
+// FileSystem keeps them.
+func (fs *boltFs) Rename(oldName, newName string) error {
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+	src, err := fs.fsPath(oldName)
+	if err != nil {
+		return linkErr(err)
+	}
+	dst, names, err := fs.fsNames(newName)
+	if err != nil {
+		return linkErr(err)
+	}
+	if fs.isRoot(src) || fs.isRoot(dst) {
+		return linkErr(os.ErrPermission)
+	}
+	dstName := names[len(names)-1]
+	if isSubPath(src, dst) {
+		if len(src) == len(dst) {
+			return fs.db.Update(func(tx Transaction) error {
+				bk, stat, err := fs.lookup(tx, oldName)
+				if err != nil {
+					return linkErr(err)
+				}
+				return renameInPlace(tx, bk, dst, stat, dstName)
+			})
+		}
+		return linkErr(os.ErrInvalid)
+	}
+
+	return fs.db.Update(func(tx Transaction) error {
+		srcBk, stat, err := fs.lookup(tx, oldName)
+		if err != nil {
+			return linkErr(err)
+		}
+		srcParent := BucketPath(src[:len(src)-1]).BucketFrom(tx)
+		dstParent, err := fs.parentBucket(tx, dst)
+		if err != nil {
+			return linkErr(err)
+		}
+		srcKey, dstKey := src[len(src)-1], dst[len(dst)-1]
+
+		moved, err := entryUsage(srcParent, srcKey, &stat)
+		if err != nil {
+			return err
+		}
+		err = addUsage(tx, src[:len(src)-1], moved.neg())
+		if err != nil {
+			return err
+		}
+		if stat.Dir {
+			if existing := dstParent.Bucket(dstKey); existing != nil {
+				if !emptyDir(existing) {
+					return linkErr(syscall.ENOTEMPTY)
+				}
+				err = dstParent.DeleteBucket(dstKey)
+				if err != nil {
+					return err
+				}
+				moved.Directories--
+			} else if dstParent.Get(dstKey) != nil {
+				return linkErr(syscall.ENOTDIR)
+			}
+			err = addUsage(tx, dst[:len(dst)-1], moved)
+			if err != nil {
+				return err
+			}
+			bk, err := dstParent.CreateBucket(dstKey)
+			if err != nil {
+				return err
+			}
+			err = copyBucket(srcParent.Bucket(srcKey), bk)
+			if err != nil {
+				return err
+			}
+			err = setDirName(bk, dstKey, dstName)
+			if err != nil {
+				return err
+			}
+			return srcParent.DeleteBucket(srcKey)
+		}
+
+		if dstParent.Bucket(dstKey) != nil {
+			return linkErr(syscall.EISDIR)
+		}
+		var replaced Usage
+		if data := dstParent.Get(dstKey); data != nil {
+			var old fileStat
+			err = msgpack.Unmarshal(data, &old)
+			if err != nil {
+				return err
+			}
+			replaced, err = entryUsage(dstParent, dstKey, &old)
+			if err != nil {
+				return err
+			}
+			// the replaced file is kept like any other overwrite, or
+			// trashed like a removal if no versions are kept
+			if fs.opts.Retention != (Retention{}) {
+				_, err = keepVersion(tx, dstParent, dstKey, &old, fs.opts.Retention)
+			} else {
+				err = fs.discard(tx, dstParent, dst, names, &old)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		stat.Filename = dstName
+		data, err := msgpack.Marshal(&stat)
+		if err != nil {
+			return err
+		}
+		err = dstParent.Put(dstKey, data)
+		if err != nil {
+			return err
+		}
+		err = moveHistory(tx, srcBk, srcKey, dstParent, dstKey)
+		if err != nil {
+			return err
+		}
+		if hb := dstParent.Bucket(historyKey(dstKey)); hb != nil && fs.opts.Retention != (Retention{}) {
+			_, err = pruneVersions(tx, hb, fs.opts.Retention, time.Now())
+			if err != nil {
+				return err
+			}
+		}
+		err = srcBk.Delete(srcKey)
+		if err != nil {
+			return err
+		}
+		u, err := entryUsage(dstParent, dstKey, &stat)
+		if err != nil {
+			return err
+		}
+		u.add(replaced.neg())
+		return addUsage(tx, dst[:len(dst)-1], u)
+	})
+}
+
+// renameInPlace changes the display name of the file or directory at p, for
+// names that differ only in case or normalization. bk and stat are the
+// result of looking it up.
+func renameInPlace(tx Transaction, bk Bucket, p BucketPath, stat fileStat, name string) error {
+	key := p[len(p)-1]
+	if stat.Dir {
+		return setDirName(BucketPath(p[:len(p)-1]).BucketFrom(tx).Bucket(key), key, name)
+	}
+	stat.Filename = name
+	data, err := msgpack.Marshal(&stat)
+	if err != nil {
+		return err
+	}
+	return bk.Put(key, data)
+}
+
+// emptyDir reports if a directory bucket has no files or directories.
+func emptyDir(bk Bucket) bool {
+	c := bk.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		if !reservedKey(k) {
+			return false
+		}
+	}
+	return true
+}
+
+// isSubPath reports if p is equal to or below parent.
+func isSubPath(parent, p BucketPath) bool {
+	if len(p) < len(parent) {
+		return false
+	}
+	for i := range parent {
+		if string(parent[i]) != string(p[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// copyBucket copies all keys and nested buckets from src into dst.
+func copyBucket(src, dst Bucket) error {
+	c := src.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		key := append([]byte{}, k...)
+		if v == nil {
+			bk, err := dst.CreateBucket(key)
+			if err != nil {
+				return err
+			}
+			err = copyBucket(src.Bucket(k), bk)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err := dst.Put(key, append([]byte{}, v...))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
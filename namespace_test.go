@@ -0,0 +1,167 @@
+package boltfs
+
+import (
+	"errors"
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	iofs "io/fs"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestNamespace(t *testing.T) {
+	Convey("When changing the namespace", t, func() {
+		os.Remove("namespace_test.db")
+		defer os.Remove("namespace_test.db")
+		db, err := bolt.Open("namespace_test.db", 0644, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fs, err := NewFileSystem(NewBoltDB(db), NewBucketPath([]byte("test")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		write := func(name, data string) {
+			wc, err := fs.Create(name)
+			So(err, ShouldBeNil)
+			io.WriteString(wc, data)
+			So(wc.Close(), ShouldBeNil)
+		}
+		read := func(name string) string {
+			f, err := fs.Open(name)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			data, err := ioutil.ReadAll(f)
+			So(err, ShouldBeNil)
+			return string(data)
+		}
+		write("a/b/c", "hello")
+
+		Convey("Should create directories only under existing parents", func() {
+			So(fs.Mkdir("a/d"), ShouldBeNil)
+			fi, err := fs.Stat("a/d")
+			So(err, ShouldBeNil)
+			So(fi.IsDir(), ShouldBeTrue)
+			So(os.IsExist(fs.Mkdir("a/d")), ShouldBeTrue)
+			So(os.IsNotExist(fs.Mkdir("x/y")), ShouldBeTrue)
+		})
+		Convey("Should remove files and empty directories", func() {
+			So(errors.Is(fs.Remove("a/b"), syscall.ENOTEMPTY), ShouldBeTrue)
+			So(fs.Remove("a/b/c"), ShouldBeNil)
+			So(fs.Remove("a/b"), ShouldBeNil)
+			So(os.IsNotExist(fs.Remove("a/b")), ShouldBeTrue)
+		})
+		Convey("Should remove a whole tree", func() {
+			So(fs.RemoveAll("a"), ShouldBeNil)
+			_, err := fs.Stat("a/b/c")
+			So(os.IsNotExist(err), ShouldBeTrue)
+			So(fs.RemoveAll("a"), ShouldBeNil)
+		})
+		Convey("Should rename files", func() {
+			So(fs.Rename("a/b/c", "a/e"), ShouldBeNil)
+			So(read("a/e"), ShouldEqual, "hello")
+			fi, err := fs.Stat("a/e")
+			So(err, ShouldBeNil)
+			So(fi.Name(), ShouldEqual, "e")
+			_, err = fs.Stat("a/b/c")
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			write("a/f", "replaced")
+			So(fs.Rename("a/e", "a/f"), ShouldBeNil)
+			So(read("a/f"), ShouldEqual, "hello")
+		})
+		Convey("Should rename directories", func() {
+			So(fs.Rename("a/b", "g"), ShouldBeNil)
+			So(read("g/c"), ShouldEqual, "hello")
+			So(fs.Rename("g", "g/h"), ShouldNotBeNil)
+			So(os.IsNotExist(fs.Rename("g", "x/y")), ShouldBeTrue)
+			write("i", "file")
+			So(errors.Is(fs.Rename("g", "i"), syscall.ENOTDIR), ShouldBeTrue)
+		})
+		Convey("Should not mix files and directories with the same name", func() {
+			_, err := fs.Create("a/b")
+			So(errors.Is(err, syscall.EISDIR), ShouldBeTrue)
+			_, err = fs.Create("a/b/c/d")
+			So(errors.Is(err, syscall.ENOTDIR), ShouldBeTrue)
+			So(errors.Is(fs.Mkdir("a/b/c/d"), syscall.ENOTDIR), ShouldBeTrue)
+
+			// the conflict appears while the file is being written
+			wc, err := fs.Create("x")
+			So(err, ShouldBeNil)
+			So(fs.Mkdir("x"), ShouldBeNil)
+			So(errors.Is(wc.Close(), syscall.EISDIR), ShouldBeTrue)
+			wc, err = fs.Create("y/z")
+			So(err, ShouldBeNil)
+			write("y", "file")
+			So(errors.Is(wc.Close(), syscall.ENOTDIR), ShouldBeTrue)
+			fi, err := fs.Stat("y")
+			So(err, ShouldBeNil)
+			So(fi.IsDir(), ShouldBeFalse)
+		})
+		Convey("Should reject invalid names", func() {
+			invalid := []string{
+				"..",
+				"a/../..",
+				"nul\x00name",
+				"a/tab\tname",
+				"bad\xffutf8",
+				strings.Repeat("n", MaxNameLength+1),
+				strings.Repeat("d/", MaxPathDepth+1),
+			}
+			for _, name := range invalid {
+				_, err := fs.Create(name)
+				So(errors.Is(err, iofs.ErrInvalid), ShouldBeTrue)
+				_, err = fs.Open(name)
+				So(errors.Is(err, iofs.ErrInvalid), ShouldBeTrue)
+				So(errors.Is(fs.Mkdir(name), iofs.ErrInvalid), ShouldBeTrue)
+				So(errors.Is(fs.Rename("a/b/c", name), iofs.ErrInvalid), ShouldBeTrue)
+				_, err = fs.GetXattr(name, "key")
+				So(errors.Is(err, iofs.ErrInvalid), ShouldBeTrue)
+			}
+			So(read("./a/b/../b/c"), ShouldEqual, "hello")
+			_, err := fs.Stat(strings.Repeat("n", MaxNameLength))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+		Convey("Should scope a sub filesystem to its directory", func() {
+			write("secret", "outside")
+			sub, err := fs.Sub("a")
+			So(err, ShouldBeNil)
+			f, err := sub.Open("/b/c")
+			So(err, ShouldBeNil)
+			data, _ := ioutil.ReadAll(f)
+			f.Close()
+			So(string(data), ShouldEqual, "hello")
+
+			_, err = sub.Stat("../secret")
+			So(errors.Is(err, os.ErrInvalid), ShouldBeTrue)
+			_, err = sub.Stat("/b/../../secret")
+			So(errors.Is(err, os.ErrInvalid), ShouldBeTrue)
+			_, err = sub.Create("../escape")
+			So(errors.Is(err, os.ErrInvalid), ShouldBeTrue)
+
+			wc, err := sub.Create("/b/../new")
+			So(err, ShouldBeNil)
+			io.WriteString(wc, "inside")
+			So(wc.Close(), ShouldBeNil)
+			So(read("a/new"), ShouldEqual, "inside")
+
+			id, err := fs.CreateUpload("up", 5, nil)
+			So(err, ShouldBeNil)
+			_, err = sub.StatUpload(id)
+			So(errors.Is(err, ErrNoUpload), ShouldBeTrue)
+
+			nested, err := sub.Sub("b")
+			So(err, ShouldBeNil)
+			fi, err := nested.Stat("c")
+			So(err, ShouldBeNil)
+			So(fi.Size(), ShouldEqual, 5)
+			_, err = sub.Sub("b/c")
+			So(errors.Is(err, syscall.ENOTDIR), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,691 @@
+package boltfs
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const whiteoutPrefix = "\x00wh:"
+
+func whiteoutKey(key []byte) []byte {
+	return append([]byte(whiteoutPrefix), key...)
+}
+
+// whiteout records that name was deleted, hiding it and everything below
+// it in the lower layers of an Overlay. It is kept when name is created
+// again, so lower directories are not merged into the new one. The parent
+// directory must exist.
+func (fs *boltFs) whiteout(name string) error {
+	p, err := fs.fsPath(name)
+	if err != nil {
+		return &os.PathError{Op: "whiteout", Path: name, Err: err}
+	}
+	if fs.isRoot(p) {
+		return &os.PathError{Op: "whiteout", Path: name, Err: os.ErrPermission}
+	}
+	return fs.db.Update(func(tx Transaction) error {
+		parent := BucketPath(p[:len(p)-1]).BucketFrom(tx)
+		if parent == nil {
+			return &os.PathError{Op: "whiteout", Path: name, Err: os.ErrNotExist}
+		}
+		return parent.Put(whiteoutKey(p[len(p)-1]), []byte{1})
+	})
+}
+
+// isWhiteout reports if there is a whiteout for name itself.
+func (fs *boltFs) isWhiteout(name string) (bool, error) {
+	p, err := fs.fsPath(name)
+	if err != nil {
+		return false, &os.PathError{Op: "whiteout", Path: name, Err: err}
+	}
+	if fs.isRoot(p) {
+		return false, nil
+	}
+	var wh bool
+	err = fs.db.View(func(tx Transaction) error {
+		parent := BucketPath(p[:len(p)-1]).BucketFrom(tx)
+		wh = parent != nil && parent.Get(whiteoutKey(p[len(p)-1])) != nil
+		return nil
+	})
+	return wh, err
+}
+
+// Overlay stacks read-only lower layers below a writable FileSystem. Reads
+// fall through to the first layer that has a path, and directories are
+// merged across layers. Changes are made in the upper FileSystem: files of
+// lower layers are copied up before they are changed, and deleting a path
+// that exists in a lower layer records a whiteout in the upper.
+//
+// Like MountFS, the Overlay is a plain FileSystem: uploads, archives and
+// Fsck are used on the upper Store directly.
+type Overlay struct {
+	upper  Store
+	lowers []http.FileSystem
+}
+
+// OverlayFS returns an Overlay writing to upper. Lower layers are searched
+// in the order given.
+func OverlayFS(upper Store, lowers ...http.FileSystem) *Overlay {
+	return &Overlay{upper: upper, lowers: lowers}
+}
+
+func statLayer(fs http.FileSystem, name string) (os.FileInfo, error) {
+	f, fi, err := openLayer(fs, name)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return fi, nil
+}
+
+// openLayer opens name in a layer along with its stat.
+func openLayer(fs http.FileSystem, name string) (http.File, os.FileInfo, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, fi, nil
+}
+
+// visible returns the lower layers that can have name. A layer is hidden
+// by a whiteout of name or one of its parents, or by a file at one of the
+// parents of name in the upper or in a layer above it.
+func (o *Overlay) visible(name string) ([]http.FileSystem, error) {
+	layers := o.lowers
+	if name == "/" {
+		return layers, nil
+	}
+	parts := strings.Split(name[1:], "/")
+	var p string
+	for i, part := range parts {
+		p += "/" + part
+		wh, err := o.upper.isWhiteout(p)
+		if err != nil {
+			return nil, err
+		}
+		if wh {
+			return nil, nil
+		}
+		if i == len(parts)-1 {
+			break
+		}
+		if fi, err := o.upper.Stat(p); err == nil && !fi.IsDir() {
+			return nil, nil
+		}
+		var dirs []http.FileSystem
+		for _, l := range layers {
+			fi, err := statLayer(l, p)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !fi.IsDir() {
+				break
+			}
+			dirs = append(dirs, l)
+		}
+		if len(dirs) == 0 {
+			return nil, nil
+		}
+		layers = dirs
+	}
+	return layers, nil
+}
+
+// lower returns the first visible lower layer that has name.
+func (o *Overlay) lower(name string) (http.FileSystem, os.FileInfo, error) {
+	layers, err := o.visible(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, l := range layers {
+		fi, err := statLayer(l, name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return l, fi, nil
+	}
+	return nil, nil, nil
+}
+
+func (o *Overlay) Stat(name string) (os.FileInfo, error) {
+	name = cleanPath(name)
+	fi, err := o.upper.Stat(name)
+	if err == nil || !os.IsNotExist(err) {
+		return fi, err
+	}
+	_, fi, err = o.lower(name)
+	if err != nil {
+		return nil, err
+	}
+	if fi == nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return fi, nil
+}
+
+// Open opens name from the first layer that has it. A directory is merged
+// from every layer that has it when it is listed. Layers may share a
+// database, and bolt can deadlock when one goroutine holds several
+// transactions, so each layer is closed before the next one is opened.
+func (o *Overlay) Open(name string) (http.File, error) {
+	name = cleanPath(name)
+	f, fi, err := openLayer(o.upper, name)
+	if err == nil && !fi.IsDir() {
+		return f, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	d := &overlayDir{o: o, name: name}
+	if err == nil {
+		f.Close()
+		d.fi, d.upper = fi, true
+	}
+
+	layers, err := o.visible(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range layers {
+		f, fi, err := openLayer(l, name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			if d.fi == nil {
+				return f, nil
+			}
+			f.Close()
+			break
+		}
+		f.Close()
+		if d.fi == nil {
+			d.fi = fi
+		}
+		d.lowers = append(d.lowers, l)
+	}
+	if d.fi == nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return d, nil
+}
+
+// emptyDir reports if the merged directory name has no entries.
+func (o *Overlay) emptyDir(name string) (bool, error) {
+	f, err := o.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	inf, err := f.Readdir(1)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return len(inf) == 0, nil
+}
+
+// copyUpDir makes sure the directory name exists in the upper, creating it
+// and its parents if they only exist in lower layers.
+func (o *Overlay) copyUpDir(name string) error {
+	fi, err := o.upper.Stat(name)
+	if err == nil {
+		if !fi.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	fi, err = o.Stat(name)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	err = o.copyUpDir(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	return o.upper.Mkdir(name)
+}
+
+// copyUp copies the lower file src to dst in the upper, keeping its
+// content type, encoding, xattrs and modification time.
+func (o *Overlay) copyUp(src, dst string) error {
+	l, fi, err := o.lower(src)
+	if err != nil {
+		return err
+	}
+	if fi == nil {
+		return &os.PathError{Op: "copy", Path: src, Err: os.ErrNotExist}
+	}
+	opts := &CreateOptions{ModTime: fi.ModTime()}
+	if meta, ok := fi.Sys().(*FileMeta); ok {
+		opts.ContentType = meta.ContentType
+		opts.ContentEncoding = meta.ContentEncoding
+		opts.Xattrs = meta.Xattrs
+	}
+
+	// a boltfs layer may share the database with the upper, so its file
+	// must not be held open while writing
+	var r io.Reader
+	if fs, ok := l.(FileSystem); ok {
+		r, err = NewReader(fs, src)
+		if err != nil {
+			return err
+		}
+	} else {
+		f, err := l.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	wc, err := o.upper.CreateWithOptions(dst, opts)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(wc, r)
+	if err != nil {
+		if a, ok := wc.(Aborter); ok {
+			a.Abort()
+		}
+		return err
+	}
+	return wc.Close()
+}
+
+// copyUpFile makes sure the file name exists in the upper.
+func (o *Overlay) copyUpFile(name string) error {
+	fi, err := o.upper.Stat(name)
+	if err == nil {
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	fi, err = o.Stat(name)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return o.copyUpDir(name)
+	}
+	err = o.copyUpDir(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	return o.copyUp(name, name)
+}
+
+func (o *Overlay) Create(name string) (io.WriteCloser, error) {
+	return o.CreateWithOptions(name, nil)
+}
+
+func (o *Overlay) CreateWithOptions(name string, opts *CreateOptions) (io.WriteCloser, error) {
+	name = cleanPath(name)
+	if fi, err := o.Stat(name); err == nil && fi.IsDir() {
+		return nil, &os.PathError{Op: "create", Path: name, Err: syscall.EISDIR}
+	}
+	err := o.copyUpDir(path.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	return o.upper.CreateWithOptions(name, opts)
+}
+
+func (o *Overlay) Mkdir(name string) error {
+	name = cleanPath(name)
+	_, err := o.Stat(name)
+	if err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	err = o.copyUpDir(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	return o.upper.Mkdir(name)
+}
+
+// remove deletes name from the upper with fn, and records a whiteout if a
+// lower layer still has it.
+func (o *Overlay) remove(name string, fn func(string) error) error {
+	err := fn(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, _, err := o.lower(name)
+	if err != nil || l == nil {
+		return err
+	}
+	err = o.copyUpDir(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	return o.upper.whiteout(name)
+}
+
+// Remove deletes a file or a directory that is empty in every layer.
+func (o *Overlay) Remove(name string) error {
+	name = cleanPath(name)
+	if name == "/" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+	}
+	fi, err := o.Stat(name)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		empty, err := o.emptyDir(name)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+	return o.remove(name, o.upper.Remove)
+}
+
+func (o *Overlay) RemoveAll(name string) error {
+	name = cleanPath(name)
+	if name == "/" {
+		return &os.PathError{Op: "removeall", Path: name, Err: os.ErrPermission}
+	}
+	_, err := o.Stat(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return o.remove(name, o.upper.RemoveAll)
+}
+
+// Rename moves a path in the upper, copying up whatever lower layers
+// contribute to it and hiding the old path with a whiteout.
+func (o *Overlay) Rename(oldName, newName string) error {
+	oldName, newName = cleanPath(oldName), cleanPath(newName)
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: err}
+	}
+	if oldName == "/" || newName == "/" {
+		return linkErr(os.ErrPermission)
+	}
+	if oldName == newName {
+		return nil
+	}
+	if strings.HasPrefix(newName, oldName+"/") {
+		return linkErr(os.ErrInvalid)
+	}
+	fi, err := o.Stat(oldName)
+	if os.IsNotExist(err) {
+		return linkErr(os.ErrNotExist)
+	}
+	if err != nil {
+		return err
+	}
+
+	dst, err := o.Stat(newName)
+	switch {
+	case err == nil && fi.IsDir() && !dst.IsDir():
+		return linkErr(syscall.ENOTDIR)
+	case err == nil && !fi.IsDir() && dst.IsDir():
+		return linkErr(syscall.EISDIR)
+	case err == nil && dst.IsDir():
+		empty, err := o.emptyDir(newName)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			return linkErr(syscall.ENOTEMPTY)
+		}
+		fallthrough
+	case err == nil:
+		err = o.RemoveAll(newName)
+	case os.IsNotExist(err):
+		err = o.copyUpDir(path.Dir(newName))
+	}
+	if err != nil {
+		return err
+	}
+	err = o.move(oldName, newName)
+	if err != nil {
+		return err
+	}
+	return o.remove(oldName, o.upper.RemoveAll)
+}
+
+// move moves src to dst in the upper, copying up what the lower layers
+// contribute to it. It leaves src in the lower layers to be hidden by the
+// caller.
+func (o *Overlay) move(src, dst string) error {
+	upperFi, err := o.upper.Stat(src)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_, fi, err := o.lower(src)
+	if err != nil {
+		return err
+	}
+	if upperFi != nil && (fi == nil || !fi.IsDir() || !upperFi.IsDir()) {
+		return o.upper.Rename(src, dst)
+	}
+	if !fi.IsDir() {
+		return o.copyUp(src, dst)
+	}
+	err = o.upper.Mkdir(dst)
+	if err != nil {
+		return err
+	}
+	return o.moveChildren(src, dst)
+}
+
+func (o *Overlay) moveChildren(src, dst string) error {
+	f, err := o.Open(src)
+	if err != nil {
+		return err
+	}
+	inf, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	for _, fi := range inf {
+		err = o.move(path.Join(src, fi.Name()), path.Join(dst, fi.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *Overlay) SetXattr(name, key string, value []byte) error {
+	name = cleanPath(name)
+	err := o.copyUpFile(name)
+	if err != nil {
+		return err
+	}
+	return o.upper.SetXattr(name, key, value)
+}
+
+func (o *Overlay) RemoveXattr(name, key string) error {
+	name = cleanPath(name)
+	err := o.copyUpFile(name)
+	if err != nil {
+		return err
+	}
+	return o.upper.RemoveXattr(name, key)
+}
+
+// lowerXattrs returns the xattrs of a file that only exists in a lower
+// layer. ok is false if the upper has the file.
+func (o *Overlay) lowerXattrs(op, name string) (xattrs map[string][]byte, ok bool, err error) {
+	_, err = o.upper.Stat(name)
+	if err == nil || !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	fi, err := o.Stat(name)
+	if err != nil {
+		return nil, true, err
+	}
+	if fi.IsDir() {
+		return nil, true, fmt.Errorf("%s %s: is a directory", op, name)
+	}
+	if meta, ok := fi.Sys().(*FileMeta); ok {
+		xattrs = meta.Xattrs
+	}
+	return xattrs, true, nil
+}
+
+func (o *Overlay) GetXattr(name, key string) ([]byte, error) {
+	name = cleanPath(name)
+	xattrs, ok, err := o.lowerXattrs("getxattr", name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return o.upper.GetXattr(name, key)
+	}
+	value, ok := xattrs[key]
+	if !ok {
+		return nil, fmt.Errorf("getxattr %s %s: %w", name, key, ErrNoXattr)
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (o *Overlay) ListXattr(name string) ([]string, error) {
+	name = cleanPath(name)
+	xattrs, ok, err := o.lowerXattrs("listxattr", name)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return o.upper.ListXattr(name)
+	}
+	var keys []string
+	for key := range xattrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// overlayDir is a directory merged from the upper, if upper is set, and
+// the lower layers that have it. Its entries are read when it is first
+// listed.
+type overlayDir struct {
+	o       *Overlay
+	name    string
+	fi      os.FileInfo
+	upper   bool
+	lowers  []http.FileSystem
+	entries []os.FileInfo
+	loaded  bool
+}
+
+func (d *overlayDir) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("is a directory")
+}
+
+func (d *overlayDir) Seek(offset int64, whence int) (int64, error) {
+	return 0, fmt.Errorf("is a directory")
+}
+
+func (d *overlayDir) Stat() (os.FileInfo, error) {
+	return d.fi, nil
+}
+
+// readLayer lists the directory name of a layer, closing it again before
+// returning.
+func readLayer(fs http.FileSystem, name string) ([]os.FileInfo, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	inf, err := f.Readdir(-1)
+	if err == io.EOF {
+		err = nil
+	}
+	return inf, err
+}
+
+func (d *overlayDir) load() error {
+	d.loaded = true
+	layers := d.lowers
+	if d.upper {
+		layers = append([]http.FileSystem{d.o.upper}, layers...)
+	}
+	seen := make(map[string]bool)
+	for i, l := range layers {
+		inf, err := readLayer(l, d.name)
+		if err != nil {
+			return err
+		}
+		for _, fi := range inf {
+			if seen[fi.Name()] {
+				continue
+			}
+			if i > 0 && d.upper {
+				wh, err := d.o.upper.isWhiteout(path.Join(d.name, fi.Name()))
+				if err != nil {
+					return err
+				}
+				if wh {
+					continue
+				}
+			}
+			seen[fi.Name()] = true
+			d.entries = append(d.entries, fi)
+		}
+	}
+	sort.Slice(d.entries, func(i, j int) bool { return d.entries[i].Name() < d.entries[j].Name() })
+	return nil
+}
+
+// Readdir follows os.File: with count > 0 it returns io.EOF once every
+// entry has been returned.
+func (d *overlayDir) Readdir(count int) ([]os.FileInfo, error) {
+	var err error
+	if !d.loaded {
+		err = d.load()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var inf []os.FileInfo
+	inf, d.entries, err = nextEntries(d.entries, count)
+	return inf, err
+}
+
+func (d *overlayDir) Close() error {
+	return nil
+}
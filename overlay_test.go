@@ -0,0 +1,176 @@
+package boltfs
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestOverlay(t *testing.T) {
+	Convey("When stacking layers below a boltfs", t, func() {
+		db, done := openTestDB(t, "overlay")
+		defer done()
+		newFS := func(name string) Store {
+			fs, err := NewFileSystem(NewBoltDB(db), NewBucketPath([]byte(name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return fs
+		}
+		names := func(o *Overlay, dir string) []string {
+			f, err := o.Open(dir)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			inf, err := f.Readdir(-1)
+			So(err, ShouldBeNil)
+			var names []string
+			for _, fi := range inf {
+				names = append(names, fi.Name())
+			}
+			return names
+		}
+
+		upper, base := newFS("upper"), newFS("base")
+		mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		wc, err := base.CreateWithOptions("css/site.css", &CreateOptions{
+			ContentType: "text/css",
+			Xattrs:      map[string][]byte{"owner": []byte("base")},
+			ModTime:     mtime,
+		})
+		So(err, ShouldBeNil)
+		io.WriteString(wc, "base css")
+		So(wc.Close(), ShouldBeNil)
+		writeFile(base, "index.html", "base index")
+		bundle := http.FS(fstest.MapFS{
+			"index.html":  {Data: []byte("bundle index")},
+			"css/app.css": {Data: []byte("app css")},
+			"img/logo":    {Data: []byte("logo")},
+		})
+		o := OverlayFS(upper, base, bundle)
+
+		Convey("Should not hold transactions while a merged directory is open", func() {
+			writeFile(upper, "css/upper.css", "upper css")
+			f, err := o.Open("css")
+			So(err, ShouldBeNil)
+			defer f.Close()
+			// growing the database remaps it, which waits for open transactions
+			done := make(chan error, 1)
+			go func() {
+				wc, err := upper.Create("big")
+				if err == nil {
+					wc.Write(make([]byte, 4<<20))
+					err = wc.Close()
+				}
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				So(err, ShouldBeNil)
+			case <-time.After(10 * time.Second):
+				t.Fatal("write blocked by an open overlay directory")
+			}
+			inf, err := f.Readdir(-1)
+			So(err, ShouldBeNil)
+			So(len(inf), ShouldEqual, 3)
+		})
+		Convey("Should read through the layers in order", func() {
+			So(readFile(o, "/index.html"), ShouldEqual, "base index")
+			So(readFile(o, "/img/logo"), ShouldEqual, "logo")
+			So(names(o, "/"), ShouldResemble, []string{"css", "img", "index.html"})
+			So(names(o, "/css"), ShouldResemble, []string{"app.css", "site.css"})
+
+			writeFile(o, "/index.html", "tenant index")
+			So(readFile(o, "/index.html"), ShouldEqual, "tenant index")
+			_, err := base.Stat("/index.html")
+			So(err, ShouldBeNil)
+		})
+		Convey("Should hide deleted lower files with whiteouts", func() {
+			So(o.Remove("/css/app.css"), ShouldBeNil)
+			_, err := o.Stat("/css/app.css")
+			So(os.IsNotExist(err), ShouldBeTrue)
+			So(names(o, "/css"), ShouldResemble, []string{"site.css"})
+			wh, err := upper.isWhiteout("/css/app.css")
+			So(err, ShouldBeNil)
+			So(wh, ShouldBeTrue)
+
+			writeFile(o, "/index.html", "tenant index")
+			So(o.Remove("/index.html"), ShouldBeNil)
+			_, err = o.Stat("/index.html")
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			err = o.Remove("/css")
+			So(errors.Is(err, syscall.ENOTEMPTY), ShouldBeTrue)
+			So(o.Remove("/css/site.css"), ShouldBeNil)
+			So(o.Remove("/css"), ShouldBeNil)
+			So(names(o, "/"), ShouldResemble, []string{"img"})
+		})
+		Convey("Should not merge lower directories into a recreated one", func() {
+			So(o.RemoveAll("/css"), ShouldBeNil)
+			So(o.Mkdir("/css"), ShouldBeNil)
+			So(names(o, "/css"), ShouldBeNil)
+			writeFile(o, "/css/new.css", "new")
+			So(names(o, "/css"), ShouldResemble, []string{"new.css"})
+		})
+		Convey("Should copy up lower files before changing xattrs", func() {
+			value, err := o.GetXattr("/css/site.css", "owner")
+			So(err, ShouldBeNil)
+			So(string(value), ShouldEqual, "base")
+			So(o.SetXattr("/css/site.css", "tenant", []byte("a")), ShouldBeNil)
+
+			keys, err := upper.ListXattr("/css/site.css")
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"owner", "tenant"})
+			fi, err := upper.Stat("/css/site.css")
+			So(err, ShouldBeNil)
+			So(fi.ModTime().Equal(mtime), ShouldBeTrue)
+			So(fi.Sys().(*FileMeta).ContentType, ShouldEqual, "text/css")
+			So(readFile(o, "/css/site.css"), ShouldEqual, "base css")
+			_, err = base.GetXattr("/css/site.css", "tenant")
+			So(errors.Is(err, ErrNoXattr), ShouldBeTrue)
+		})
+		Convey("Should rename merged directories", func() {
+			writeFile(o, "/css/tenant.css", "tenant")
+			So(o.Rename("/css", "/style"), ShouldBeNil)
+			So(names(o, "/style"), ShouldResemble, []string{"app.css", "site.css", "tenant.css"})
+			So(readFile(o, "/style/app.css"), ShouldEqual, "app css")
+			_, err := o.Stat("/css")
+			So(os.IsNotExist(err), ShouldBeTrue)
+			So(names(o, "/"), ShouldResemble, []string{"img", "index.html", "style"})
+
+			err = o.Rename("/style", "/index.html")
+			So(errors.Is(err, syscall.ENOTDIR), ShouldBeTrue)
+		})
+		Convey("Should create files in lower directories", func() {
+			writeFile(o, "/img/icon", "icon")
+			So(names(o, "/img"), ShouldResemble, []string{"icon", "logo"})
+			_, err := o.Create("/missing/file")
+			So(os.IsNotExist(err), ShouldBeTrue)
+			_, err = o.Create("/img")
+			So(errors.Is(err, syscall.EISDIR), ShouldBeTrue)
+		})
+		Convey("Should not list whiteouts in the upper", func() {
+			So(o.Remove("/index.html"), ShouldBeNil)
+			So(o.Remove("/css/app.css"), ShouldBeNil)
+			f, err := upper.Open("/")
+			So(err, ShouldBeNil)
+			inf, err := f.Readdir(-1)
+			f.Close()
+			So(err, ShouldBeNil)
+			So(inf, ShouldHaveLength, 1)
+			So(inf[0].Name(), ShouldEqual, "css")
+			So(upper.Remove("/css"), ShouldBeNil)
+			_, err = upper.Stat("/index.html")
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			report, err := upper.Fsck(false)
+			So(err, ShouldBeNil)
+			So(report.Problems, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,153 @@
+package boltfs
+
+import (
+	"errors"
+	"gopkg.in/vmihailenco/msgpack.v2"
+	"os"
+	"syscall"
+)
+
+// quotaKey holds the quota of a directory within its bucket.
+const quotaKey = "\x00quota"
+
+// ErrQuotaExceeded is returned when a change would take a directory tree
+// over its quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota limits the space a directory tree may take. A zero limit is
+// unlimited.
+type Quota struct {
+	Bytes int64
+	Files int64
+}
+
+// exceeds reports if growing by d took the usage u over q. Shrinking never
+// exceeds a quota, so a tree that is already over it can be cleaned up.
+func (q Quota) exceeds(u, d Usage) bool {
+	return q.Bytes > 0 && d.Bytes > 0 && u.Bytes > q.Bytes ||
+		q.Files > 0 && d.Files > 0 && u.Files > q.Files
+}
+
+// checkQuota returns ErrQuotaExceeded if growing the directory bk by d,
+// to the usage u, exceeds its quota.
+func checkQuota(bk Bucket, u, d Usage) error {
+	if d.Bytes <= 0 && d.Files <= 0 {
+		return nil
+	}
+	data := bk.Get([]byte(quotaKey))
+	if data == nil {
+		return nil
+	}
+	var q Quota
+	err := msgpack.Unmarshal(data, &q)
+	if err != nil {
+		return err
+	}
+	if q.exceeds(u, d) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// SetQuota limits the space taken by the directory name and everything
+// below it. Writes that would exceed the quota fail with
+// ErrQuotaExceeded, and a zero Quota removes it. Quotas need the counters
+// of a FileSystem created with Options.Counters. A FileSystem returned by
+// Sub cannot change the quota of its own root, which limits it.
+func (fs *boltFs) SetQuota(name string, q Quota) error {
+	if !fs.opts.Counters {
+		return &os.PathError{Op: "setquota", Path: name, Err: errors.ErrUnsupported}
+	}
+	p, err := fs.fsPath(name)
+	if err != nil {
+		return &os.PathError{Op: "setquota", Path: name, Err: err}
+	}
+	if fs.isSub() && fs.isRoot(p) {
+		return &os.PathError{Op: "setquota", Path: name, Err: os.ErrPermission}
+	}
+	return fs.db.Update(func(tx Transaction) error {
+		_, stat, err := fs.lookup(tx, name)
+		if err != nil {
+			return &os.PathError{Op: "setquota", Path: name, Err: err}
+		}
+		if !stat.Dir {
+			return &os.PathError{Op: "setquota", Path: name, Err: syscall.ENOTDIR}
+		}
+		bk := p.BucketFrom(tx)
+		if q == (Quota{}) {
+			return bk.Delete([]byte(quotaKey))
+		}
+		data, err := msgpack.Marshal(&q)
+		if err != nil {
+			return err
+		}
+		return bk.Put([]byte(quotaKey), data)
+	})
+}
+
+// GetQuota returns the quota of the directory name, or a zero Quota if it
+// has none.
+func (fs *boltFs) GetQuota(name string) (Quota, error) {
+	var q Quota
+	p, err := fs.fsPath(name)
+	if err != nil {
+		return q, &os.PathError{Op: "getquota", Path: name, Err: err}
+	}
+	err = fs.db.View(func(tx Transaction) error {
+		_, stat, err := fs.lookup(tx, name)
+		if err != nil {
+			return &os.PathError{Op: "getquota", Path: name, Err: err}
+		}
+		if !stat.Dir {
+			return &os.PathError{Op: "getquota", Path: name, Err: syscall.ENOTDIR}
+		}
+		data := p.BucketFrom(tx).Get([]byte(quotaKey))
+		if data == nil {
+			return nil
+		}
+		return msgpack.Unmarshal(data, &q)
+	})
+	return q, err
+}
+
+// checkSpace returns ErrQuotaExceeded if storing length bytes at p, in
+// place of any file already there, would take a directory above it over
+// its quota. A file that is kept as a version still takes its space.
+func (fs *boltFs) checkSpace(op, name string, p BucketPath, length int64) error {
+	if !fs.opts.Counters {
+		return nil
+	}
+	return fs.db.View(func(tx Transaction) error {
+		d := Usage{Bytes: length, Files: 1}
+		if parent := BucketPath(p[:len(p)-1]).BucketFrom(tx); parent != nil {
+			var old fileStat
+			if data := parent.Get(p[len(p)-1]); data != nil && msgpack.Unmarshal(data, &old) == nil {
+				d.add(statUsage(&old).neg())
+				if fs.opts.Retention != (Retention{}) {
+					d.add(retainedUsage(&old))
+				}
+			}
+		}
+		var bk Bucket
+		for _, key := range p[:len(p)-1] {
+			if bk == nil {
+				bk = tx.Bucket(key)
+			} else {
+				bk = bk.Bucket(key)
+			}
+			if bk == nil {
+				return nil
+			}
+			u, err := readUsage(bk)
+			if err != nil {
+				return err
+			}
+			u.add(d)
+			err = checkQuota(bk, u, d)
+			if err != nil {
+				return &os.PathError{Op: op, Path: name, Err: err}
+			}
+		}
+		return nil
+	})
+}
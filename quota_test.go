@@ -0,0 +1,103 @@
+package boltfs
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	Convey("When limiting a directory with a quota", t, func() {
+		db, fs, done := openTestFS(t, "quota", &Options{Counters: true})
+		defer done()
+		write := func(name string, n int) error {
+			wc, err := fs.Create(name)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(wc, strings.Repeat("x", n))
+			if err != nil {
+				return err
+			}
+			return wc.Close()
+		}
+		So(fs.Mkdir("tenant"), ShouldBeNil)
+		So(fs.SetQuota("tenant", Quota{Bytes: 100, Files: 3}), ShouldBeNil)
+
+		Convey("Should store the quota", func() {
+			q, err := fs.GetQuota("tenant")
+			So(err, ShouldBeNil)
+			So(q, ShouldResemble, Quota{Bytes: 100, Files: 3})
+			q, err = fs.GetQuota("/")
+			So(err, ShouldBeNil)
+			So(q, ShouldResemble, Quota{})
+			So(fs.SetQuota("tenant", Quota{}), ShouldBeNil)
+			So(write("tenant/big", 200), ShouldBeNil)
+		})
+		Convey("Should reject writes over the byte limit", func() {
+			So(write("tenant/a", 60), ShouldBeNil)
+			err := write("tenant/b/c", 50)
+			So(errors.Is(err, ErrQuotaExceeded), ShouldBeTrue)
+			_, err = fs.Stat("tenant/b/c")
+			So(os.IsNotExist(err), ShouldBeTrue)
+			So(write("outside", 200), ShouldBeNil)
+
+			// replacing a file only counts the difference
+			So(write("tenant/a", 90), ShouldBeNil)
+			report, err := fs.Fsck(false)
+			So(err, ShouldBeNil)
+			So(report.Orphans, ShouldEqual, 0)
+		})
+		Convey("Should reject files over the count limit", func() {
+			for _, name := range []string{"a", "b", "c"} {
+				So(write("tenant/"+name, 1), ShouldBeNil)
+			}
+			So(errors.Is(write("tenant/d", 1), ErrQuotaExceeded), ShouldBeTrue)
+			So(errors.Is(fs.Rename("tenant/a", "tenant/e"), ErrQuotaExceeded), ShouldBeFalse)
+			So(fs.Remove("tenant/b"), ShouldBeNil)
+			So(write("tenant/d", 1), ShouldBeNil)
+		})
+		Convey("Should check moves, uploads and imports", func() {
+			So(write("big", 150), ShouldBeNil)
+			So(errors.Is(fs.Rename("big", "tenant/big"), ErrQuotaExceeded), ShouldBeTrue)
+			_, err := fs.Stat("big")
+			So(err, ShouldBeNil)
+
+			_, err = fs.CreateUpload("tenant/up", 150, nil)
+			So(errors.Is(err, ErrQuotaExceeded), ShouldBeTrue)
+
+			sub, err := fs.Sub("tenant")
+			So(err, ShouldBeNil)
+			wc, err := sub.Create("nested")
+			So(err, ShouldBeNil)
+			_, err = io.WriteString(wc, strings.Repeat("x", int(blockSize)))
+			So(errors.Is(err, ErrQuotaExceeded), ShouldBeTrue)
+			So(wc.Close(), ShouldNotBeNil)
+
+			// smaller writes are only checked when the file is closed
+			wc, err = sub.Create("nested")
+			So(err, ShouldBeNil)
+			_, err = io.WriteString(wc, strings.Repeat("x", 150))
+			So(err, ShouldBeNil)
+			So(errors.Is(wc.Close(), ErrQuotaExceeded), ShouldBeTrue)
+			_, err = sub.Stat("nested")
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			So(os.IsPermission(sub.SetQuota("/", Quota{})), ShouldBeTrue)
+			q, err := fs.GetQuota("tenant")
+			So(err, ShouldBeNil)
+			So(q, ShouldNotResemble, Quota{})
+			So(sub.Mkdir("inner"), ShouldBeNil)
+			So(sub.SetQuota("inner", Quota{Files: 1}), ShouldBeNil)
+		})
+		Convey("Should need counters", func() {
+			plain, err := NewFileSystem(NewBoltDB(db), NewBucketPath([]byte("plain")))
+			So(err, ShouldBeNil)
+			So(plain.Mkdir("dir"), ShouldBeNil)
+			So(errors.Is(plain.SetQuota("dir", Quota{Bytes: 1}), errors.ErrUnsupported), ShouldBeTrue)
+		})
+	})
+}
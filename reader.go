@@ -0,0 +1,102 @@
+package boltfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// ErrFileChanged is returned by a Reader when the file was replaced after
+// the Reader was created.
+var ErrFileChanged = fmt.Errorf("file changed while reading")
+
+// Reader reads a file without holding a read transaction between calls.
+// An open http.File from Open keeps its transaction until Close, and bolt
+// cannot grow the database while one is open, so a goroutine that writes
+// while holding a file open can block forever. Reader opens the file again
+// for each read instead, and fails with ErrFileChanged if it was replaced.
+type Reader struct {
+	fs   FileSystem
+	name string
+	fi   os.FileInfo
+	pos  int64
+}
+
+// NewReader returns a Reader for the current version of the file name.
+func NewReader(fs FileSystem, name string) (*Reader, error) {
+	fi, err := fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+	return &Reader{fs: fs, name: name, fi: fi}, nil
+}
+
+// Stat returns the file info the Reader was created with.
+func (r *Reader) Stat() os.FileInfo {
+	return r.fi
+}
+
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.fi.Size() {
+		return 0, io.EOF
+	}
+	f, err := r.fs.Open(r.name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if fileETag(fi) != fileETag(r.fi) {
+		return 0, &os.PathError{Op: "read", Path: r.name, Err: ErrFileChanged}
+	}
+	_, err = f.Seek(off, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(f, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	pos := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		pos += r.pos
+	case io.SeekEnd:
+		pos += r.fi.Size()
+	default:
+		return r.pos, fmt.Errorf("expected whence to be 0,1, or 2")
+	}
+	if pos < 0 || pos > r.fi.Size() {
+		return r.pos, fmt.Errorf("new position is beyond contents of file")
+	}
+	r.pos = pos
+	return pos, nil
+}
+
+func fileETag(fi os.FileInfo) string {
+	if meta, ok := fi.Sys().(*FileMeta); ok {
+		return meta.ETag
+	}
+	return ""
+}
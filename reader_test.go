@@ -0,0 +1,108 @@
+package boltfs
+
+import (
+	"errors"
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	Convey("When reading with a Reader", t, func() {
+		os.Remove("reader_test.db")
+		defer os.Remove("reader_test.db")
+		db, err := bolt.Open("reader_test.db", 0644, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fs, err := NewFileSystem(NewBoltDB(db), NewBucketPath([]byte("test")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wc, _ := fs.Create("foo")
+		io.WriteString(wc, "hello world")
+		wc.Close()
+
+		r, err := NewReader(fs, "foo")
+		So(err, ShouldBeNil)
+
+		Convey("Should read, seek and read at offsets", func() {
+			buf := make([]byte, 10)
+			n, err := r.ReadAt(buf, 6)
+			So(n, ShouldEqual, 5)
+			So(string(buf[:n]), ShouldEqual, "world")
+			So(err, ShouldEqual, io.EOF)
+
+			r.Seek(6, io.SeekStart)
+			data, err := ioutil.ReadAll(r)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "world")
+		})
+		Convey("Should allow writes between reads", func() {
+			buf := make([]byte, 5)
+			r.Read(buf)
+			wc, _ := fs.Create("bar")
+			io.WriteString(wc, "other")
+			So(wc.Close(), ShouldBeNil)
+			_, err := r.Read(buf)
+			So(err, ShouldBeNil)
+		})
+		Convey("Should fail if the file is replaced", func() {
+			wc, _ := fs.Create("foo")
+			io.WriteString(wc, "replaced")
+			wc.Close()
+			_, err := ioutil.ReadAll(r)
+			So(errors.Is(err, ErrFileChanged), ShouldBeTrue)
+		})
+		Convey("Should refuse directories", func() {
+			wc, _ := fs.Create("dir/file")
+			wc.Close()
+			_, err := NewReader(fs, "dir")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestLargeFile(t *testing.T) {
+	Convey("When reading a file with more than 256 blocks", t, func() {
+		os.Remove("large_test.db")
+		defer os.Remove("large_test.db")
+		db, err := bolt.Open("large_test.db", 0644, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fs, err := NewFileSystem(NewBoltDB(db), NewBucketPath([]byte("test")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wc, _ := fs.Create("big")
+		block := make([]byte, blockSize)
+		for i := 0; i < 300; i++ {
+			for j := range block {
+				block[j] = byte(i)
+			}
+			wc.Write(block)
+		}
+		So(wc.Close(), ShouldBeNil)
+
+		Convey("Should return the blocks in order", func() {
+			f, err := fs.Open("big")
+			So(err, ShouldBeNil)
+			defer f.Close()
+			data, err := ioutil.ReadAll(f)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldEqual, 300*blockSize)
+			for i := 0; i < 300; i++ {
+				if data[int64(i)*blockSize] != byte(i) {
+					So(data[int64(i)*blockSize], ShouldEqual, byte(i))
+					break
+				}
+			}
+		})
+	})
+}
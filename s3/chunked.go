@@ -0,0 +1,101 @@
+package s3
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// requestBody returns the object data of a PutObject or UploadPart
+// request, decoding the aws-chunked framing that SDKs use for streaming
+// signatures and trailing checksums. Chunk signatures and trailers are not
+// verified.
+func requestBody(r *http.Request) (io.Reader, error) {
+	sha := r.Header.Get("X-Amz-Content-Sha256")
+	if !strings.HasPrefix(sha, "STREAMING-") && !strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		return r.Body, nil
+	}
+	if dl := r.Header.Get("X-Amz-Decoded-Content-Length"); dl != "" {
+		n, err := strconv.ParseInt(dl, 10, 64)
+		if err != nil || n < 0 {
+			return nil, errInvalidArgument
+		}
+		r.ContentLength = n
+	}
+	return &chunkedReader{r: bufio.NewReader(r.Body)}, nil
+}
+
+type chunkedReader struct {
+	r    *bufio.Reader
+	left int64
+	done bool
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.left == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		line, err := c.readLine()
+		if err != nil {
+			return 0, err
+		}
+		if i := strings.IndexByte(line, ';'); i >= 0 {
+			line = line[:i]
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+		if err != nil || size < 0 {
+			return 0, fmt.Errorf("invalid aws-chunked size %q", line)
+		}
+		if size == 0 {
+			// skip trailers up to the terminating empty line
+			for {
+				line, err = c.readLine()
+				if err != nil {
+					return 0, err
+				}
+				if line == "" {
+					break
+				}
+			}
+			c.done = true
+			return 0, io.EOF
+		}
+		c.left = size
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return n, err
+	}
+	if c.left == 0 {
+		line, err := c.readLine()
+		if err != nil {
+			return n, err
+		}
+		if line != "" {
+			return n, fmt.Errorf("invalid aws-chunked framing")
+		}
+	}
+	return n, nil
+}
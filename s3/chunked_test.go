@@ -0,0 +1,41 @@
+package s3
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRequestBody(t *testing.T) {
+	Convey("When decoding request bodies", t, func() {
+		Convey("Should pass plain bodies through", func() {
+			r, _ := http.NewRequest("PUT", "/b/k", strings.NewReader("hello"))
+			body, err := requestBody(r)
+			So(err, ShouldBeNil)
+			data, _ := ioutil.ReadAll(body)
+			So(string(data), ShouldEqual, "hello")
+		})
+		Convey("Should decode aws-chunked bodies with signatures and trailers", func() {
+			r, _ := http.NewRequest("PUT", "/b/k", strings.NewReader(
+				"5;chunk-signature=abc\r\nhello\r\n6\r\n world\r\n0\r\nx-amz-checksum-crc32:AAAAAA==\r\n\r\n"))
+			r.Header.Set("X-Amz-Content-Sha256", "STREAMING-UNSIGNED-PAYLOAD-TRAILER")
+			r.Header.Set("X-Amz-Decoded-Content-Length", "11")
+			body, err := requestBody(r)
+			So(err, ShouldBeNil)
+			So(r.ContentLength, ShouldEqual, 11)
+			data, err := ioutil.ReadAll(body)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "hello world")
+		})
+		Convey("Should fail on truncated bodies", func() {
+			r, _ := http.NewRequest("PUT", "/b/k", strings.NewReader("5\r\nhel"))
+			r.Header.Set("Content-Encoding", "aws-chunked")
+			body, err := requestBody(r)
+			So(err, ShouldBeNil)
+			_, err = ioutil.ReadAll(body)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,168 @@
+package s3
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const maxKeys = 1000
+
+type object struct {
+	key string
+	fi  os.FileInfo
+}
+
+type listEntry struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Xmlns                 string   `xml:"xmlns,attr"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	MaxKeys               int
+	KeyCount              int
+	IsTruncated           bool
+	Contents              []listEntry
+	CommonPrefixes        []commonPrefix
+}
+
+// walk returns every object under dir, keyed relative to the bucket, in
+// lexical order. Only directories that can contain keys matching prefix
+// are descended into.
+func (h *Handler) walk(dir, key, prefix string) ([]object, error) {
+	f, err := h.fs.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	inf, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	var objs []object
+	for _, fi := range inf {
+		k := key + fi.Name()
+		if !fi.IsDir() {
+			objs = append(objs, object{key: k, fi: fi})
+			continue
+		}
+		k += "/"
+		if !strings.HasPrefix(k, prefix) && !strings.HasPrefix(prefix, k) {
+			continue
+		}
+		sub, err := h.walk(path.Join(dir, fi.Name()), k, prefix)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, sub...)
+	}
+	return objs, nil
+}
+
+func (h *Handler) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	err := h.checkBucket(bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	q := r.URL.Query()
+	res := listBucketResult{
+		Xmlns:             xmlns,
+		Name:              bucket,
+		Prefix:            q.Get("prefix"),
+		Delimiter:         q.Get("delimiter"),
+		StartAfter:        q.Get("start-after"),
+		ContinuationToken: q.Get("continuation-token"),
+		MaxKeys:           maxKeys,
+	}
+	if s := q.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, r, errInvalidArgument)
+			return
+		}
+		if n < maxKeys {
+			res.MaxKeys = n
+		}
+	}
+	after := res.StartAfter
+	if res.ContinuationToken != "" {
+		data, err := base64.RawURLEncoding.DecodeString(res.ContinuationToken)
+		if err != nil {
+			writeError(w, r, &s3Error{Code: "InvalidArgument", Message: "The continuation token provided is incorrect.", status: http.StatusBadRequest})
+			return
+		}
+		after = string(data)
+	}
+
+	objs, err := h.walk("/"+bucket, "", res.Prefix)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	sort.Slice(objs, func(i, j int) bool { return objs[i].key < objs[j].key })
+
+	var last string
+	for _, obj := range objs {
+		if obj.key <= after || !strings.HasPrefix(obj.key, res.Prefix) {
+			continue
+		}
+		entry := obj.key
+		var cp string
+		if res.Delimiter != "" {
+			if i := strings.Index(obj.key[len(res.Prefix):], res.Delimiter); i >= 0 {
+				cp = obj.key[:len(res.Prefix)+i+len(res.Delimiter)]
+				entry = cp
+			}
+		}
+		if cp != "" && len(res.CommonPrefixes) > 0 && res.CommonPrefixes[len(res.CommonPrefixes)-1].Prefix == cp {
+			continue
+		}
+		if res.KeyCount == res.MaxKeys {
+			res.IsTruncated = true
+			break
+		}
+		if cp != "" {
+			res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{Prefix: cp})
+		} else {
+			res.Contents = append(res.Contents, listEntry{
+				Key:          obj.key,
+				LastModified: formatTime(obj.fi.ModTime()),
+				ETag:         objectETag(obj.fi),
+				Size:         obj.fi.Size(),
+				StorageClass: "STANDARD",
+			})
+		}
+		res.KeyCount++
+		last = entry
+	}
+	if res.IsTruncated {
+		// a common prefix sorts before the keys it covers, so continuing
+		// after it with a trailing 0xff byte skips them all
+		if strings.HasSuffix(last, res.Delimiter) && res.Delimiter != "" {
+			last += "\xff"
+		}
+		res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+	}
+	writeXML(w, res)
+}
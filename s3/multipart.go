@@ -0,0 +1,253 @@
+package s3
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/mastercactapus/boltfs"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Multipart uploads are staged under /<id> of the Handler's staging
+// FileSystem, outside of the served namespace. The upload file holds the
+// object's content type, encoding and metadata as its own, along with the
+// destination, and each part is stored as its own file until the upload is
+// completed or aborted.
+
+const (
+	uploadFile    = "upload"
+	keyXattr      = "s3.key"
+	maxPartNumber = 10000
+)
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string
+	Key      string
+	UploadId string
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int
+		ETag       string
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string
+	Bucket   string
+	Key      string
+	ETag     string
+}
+
+func uploadPath(id string) (string, error) {
+	if len(id) != 32 {
+		return "", errNoSuchUpload
+	}
+	_, err := hex.DecodeString(id)
+	if err != nil {
+		return "", errNoSuchUpload
+	}
+	return "/" + id, nil
+}
+
+func partPath(dir string, n int) string {
+	return path.Join(dir, fmt.Sprintf("part-%05d", n))
+}
+
+func (h *Handler) createUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	var id [16]byte
+	_, err := rand.Read(id[:])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	staging, err := h.staging()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	dir := "/" + hex.EncodeToString(id[:])
+	err = staging.Mkdir(dir)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	xattrs := map[string][]byte{keyXattr: []byte(path.Join("/", bucket, key))}
+	for k, v := range r.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-meta-") && len(v) > 0 {
+			xattrs[metaXattrPrefix+strings.TrimPrefix(lk, "x-amz-meta-")] = []byte(v[0])
+		}
+	}
+	wc, err := staging.CreateWithOptions(path.Join(dir, uploadFile), &boltfs.CreateOptions{
+		ContentType:     r.Header.Get("Content-Type"),
+		ContentEncoding: contentEncoding(r),
+		Xattrs:          xattrs,
+	})
+	if err == nil {
+		err = wc.Close()
+	}
+	if err != nil {
+		staging.RemoveAll(dir)
+		writeError(w, r, err)
+		return
+	}
+
+	writeXML(w, initiateMultipartUploadResult{
+		Xmlns:    xmlns,
+		Bucket:   bucket,
+		Key:      key,
+		UploadId: path.Base(dir),
+	})
+}
+
+// upload returns the staging FileSystem, the upload's directory in it and
+// the upload file info for id, which must be an upload to the object name.
+func (h *Handler) upload(id, name string) (boltfs.FileSystem, string, *boltfs.FileMeta, error) {
+	dir, err := uploadPath(id)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	staging, err := h.staging()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	fi, err := staging.Stat(path.Join(dir, uploadFile))
+	if os.IsNotExist(err) {
+		return nil, "", nil, errNoSuchUpload
+	}
+	if err != nil {
+		return nil, "", nil, err
+	}
+	meta, ok := fi.Sys().(*boltfs.FileMeta)
+	if !ok || string(meta.Xattrs[keyXattr]) != name {
+		return nil, "", nil, errNoSuchUpload
+	}
+	return staging, dir, meta, nil
+}
+
+func (h *Handler) uploadPart(w http.ResponseWriter, r *http.Request, name, id, partNumber string) {
+	n, err := strconv.Atoi(partNumber)
+	if err != nil || n < 1 || n > maxPartNumber {
+		writeError(w, r, errInvalidArgument)
+		return
+	}
+	staging, dir, _, err := h.upload(id, name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	etag, err := h.store(staging, r, partPath(dir, n), nil)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag)
+}
+
+func (h *Handler) completeUpload(w http.ResponseWriter, r *http.Request, bucket, key, id string) {
+	name := path.Join("/", bucket, key)
+	staging, dir, meta, err := h.upload(id, name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var req completeMultipartUpload
+	err = xml.NewDecoder(r.Body).Decode(&req)
+	if err != nil || len(req.Parts) == 0 {
+		writeError(w, r, errMalformedXML)
+		return
+	}
+
+	parts := make([]*boltfs.Reader, len(req.Parts))
+	sums := md5.New()
+	for i, p := range req.Parts {
+		if i > 0 && p.PartNumber <= req.Parts[i-1].PartNumber {
+			writeError(w, r, &s3Error{Code: "InvalidPartOrder", Message: "The list of parts was not in ascending order.", status: http.StatusBadRequest})
+			return
+		}
+		if p.PartNumber < 1 || p.PartNumber > maxPartNumber {
+			writeError(w, r, errInvalidPart)
+			return
+		}
+		rd, err := boltfs.NewReader(staging, partPath(dir, p.PartNumber))
+		if err != nil {
+			writeError(w, r, errInvalidPart)
+			return
+		}
+		etag := objectETag(rd.Stat())
+		if strings.Trim(p.ETag, `"`) != strings.Trim(etag, `"`) {
+			writeError(w, r, errInvalidPart)
+			return
+		}
+		sum, _ := hex.DecodeString(strings.Trim(etag, `"`))
+		sums.Write(sum)
+		parts[i] = rd
+	}
+
+	xattrs := make(map[string][]byte)
+	for k, v := range meta.Xattrs {
+		if strings.HasPrefix(k, metaXattrPrefix) {
+			xattrs[k] = v
+		}
+	}
+	wc, err := h.fs.CreateWithOptions(name, &boltfs.CreateOptions{
+		ContentType:     meta.ContentType,
+		ContentEncoding: meta.ContentEncoding,
+		Xattrs:          xattrs,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for _, rd := range parts {
+		_, err = io.Copy(wc, rd)
+		if err != nil {
+			if a, ok := wc.(boltfs.Aborter); ok {
+				a.Abort()
+			}
+			writeError(w, r, err)
+			return
+		}
+	}
+	etag := fmt.Sprintf(`"%x-%d"`, sums.Sum(nil), len(parts))
+	err = closeWithETag(h.fs, wc, name, etag)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	staging.RemoveAll(dir)
+
+	writeXML(w, completeMultipartUploadResult{
+		Xmlns:    xmlns,
+		Location: name,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     etag,
+	})
+}
+
+func (h *Handler) abortUpload(w http.ResponseWriter, r *http.Request, name, id string) {
+	staging, dir, _, err := h.upload(id, name)
+	if err == nil {
+		err = staging.RemoveAll(dir)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,472 @@
+// Package s3 serves a boltfs FileSystem through a minimal, path-style S3
+// REST API. Each S3 bucket is a top level directory and object keys are
+// paths below it, so keys must be valid boltfs paths: no empty, "." or ".."
+// segments and no trailing slash.
+//
+// Supported operations are ListBuckets, CreateBucket, HeadBucket,
+// DeleteBucket, PutObject, GetObject (with Range), HeadObject, DeleteObject,
+// ListObjectsV2 and multipart uploads. Requests are not authenticated
+// unless an Authorize hook is provided.
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"github.com/mastercactapus/boltfs"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+	etagXattr       = "s3.etag"
+	metaXattrPrefix = "s3.meta."
+)
+
+// Options configure a Handler.
+type Options struct {
+	// Authorize is called before every request. A non-nil error is returned
+	// to the client as AccessDenied.
+	Authorize func(r *http.Request) error
+
+	// MaxObjectSize limits the size of a single PutObject or UploadPart, if
+	// greater than zero.
+	MaxObjectSize int64
+
+	// Staging holds multipart uploads in progress. If nil, the staging
+	// area of the served boltfs.Store is used; multipart uploads are not
+	// implemented for other FileSystems.
+	Staging boltfs.FileSystem
+}
+
+// Handler implements the S3 API on top of a boltfs FileSystem.
+type Handler struct {
+	fs   boltfs.FileSystem
+	opts Options
+}
+
+// NewHandler returns a Handler serving fs. opts may be nil.
+func NewHandler(fs boltfs.FileSystem, opts *Options) *Handler {
+	h := &Handler{fs: fs}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+// staging returns the FileSystem multipart uploads are staged in.
+func (h *Handler) staging() (boltfs.FileSystem, error) {
+	if h.opts.Staging != nil {
+		return h.opts.Staging, nil
+	}
+	st, ok := h.fs.(boltfs.Store)
+	if !ok {
+		return nil, errNotImplemented
+	}
+	return st.Staging()
+}
+
+type s3Error struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string
+	status   int
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	errNoSuchBucket     = &s3Error{Code: "NoSuchBucket", Message: "The specified bucket does not exist.", status: http.StatusNotFound}
+	errNoSuchKey        = &s3Error{Code: "NoSuchKey", Message: "The specified key does not exist.", status: http.StatusNotFound}
+	errNoSuchUpload     = &s3Error{Code: "NoSuchUpload", Message: "The specified multipart upload does not exist.", status: http.StatusNotFound}
+	errBucketNotEmpty   = &s3Error{Code: "BucketNotEmpty", Message: "The bucket you tried to delete is not empty.", status: http.StatusConflict}
+	errBucketExists     = &s3Error{Code: "BucketAlreadyOwnedByYou", Message: "The bucket already exists.", status: http.StatusConflict}
+	errInvalidBucket    = &s3Error{Code: "InvalidBucketName", Message: "The specified bucket is not valid.", status: http.StatusBadRequest}
+	errInvalidKey       = &s3Error{Code: "InvalidArgument", Message: "The object key cannot be stored.", status: http.StatusBadRequest}
+	errInvalidPart      = &s3Error{Code: "InvalidPart", Message: "One or more of the specified parts could not be found.", status: http.StatusBadRequest}
+	errInvalidArgument  = &s3Error{Code: "InvalidArgument", Message: "Invalid argument.", status: http.StatusBadRequest}
+	errMalformedXML     = &s3Error{Code: "MalformedXML", Message: "The XML you provided was not well-formed.", status: http.StatusBadRequest}
+	errTooLarge         = &s3Error{Code: "EntityTooLarge", Message: "Your proposed upload exceeds the maximum allowed size.", status: http.StatusBadRequest}
+	errNotImplemented   = &s3Error{Code: "NotImplemented", Message: "The requested functionality is not implemented.", status: http.StatusNotImplemented}
+	errMethodNotAllowed = &s3Error{Code: "MethodNotAllowed", Message: "The specified method is not allowed against this resource.", status: http.StatusMethodNotAllowed}
+)
+
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var e *s3Error
+	if !errors.As(err, &e) {
+		switch {
+		case os.IsNotExist(err):
+			e = errNoSuchKey
+		case errors.Is(err, os.ErrInvalid):
+			e = errInvalidArgument
+		case errors.Is(err, boltfs.ErrQuotaExceeded):
+			e = errTooLarge
+		default:
+			e = &s3Error{Code: "InternalError", Message: err.Error(), status: http.StatusInternalServerError}
+		}
+	}
+	resp := *e
+	resp.Resource = r.URL.Path
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(e.status)
+	if r.Method != "HEAD" {
+		io.WriteString(w, xml.Header)
+		xml.NewEncoder(w).Encode(resp)
+	}
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func validBucket(bucket string) bool {
+	if len(bucket) < 3 || len(bucket) > 63 {
+		return false
+	}
+	for i, c := range bucket {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case (c == '-' || c == '.') && i > 0 && i < len(bucket)-1:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func validKey(key string) bool {
+	if key == "" || strings.HasSuffix(key, "/") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.opts.Authorize != nil {
+		err := h.opts.Authorize(r)
+		if err != nil {
+			writeError(w, r, &s3Error{Code: "AccessDenied", Message: err.Error(), status: http.StatusForbidden})
+			return
+		}
+	}
+
+	bucket, key := strings.TrimPrefix(r.URL.Path, "/"), ""
+	if i := strings.IndexByte(bucket, '/'); i >= 0 {
+		bucket, key = bucket[:i], bucket[i+1:]
+	}
+	if bucket == "" {
+		if r.Method != "GET" {
+			writeError(w, r, errMethodNotAllowed)
+			return
+		}
+		h.listBuckets(w, r)
+		return
+	}
+	if !validBucket(bucket) {
+		writeError(w, r, errInvalidBucket)
+		return
+	}
+	if key == "" {
+		h.serveBucket(w, r, bucket)
+		return
+	}
+	if !validKey(key) {
+		writeError(w, r, errInvalidKey)
+		return
+	}
+	h.serveObject(w, r, bucket, key)
+}
+
+func (h *Handler) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) {
+	q := r.URL.Query()
+	switch r.Method {
+	case "GET":
+		if q.Get("list-type") != "2" {
+			writeError(w, r, errNotImplemented)
+			return
+		}
+		h.listObjects(w, r, bucket)
+	case "HEAD":
+		err := h.checkBucket(bucket)
+		if err != nil {
+			writeError(w, r, err)
+		}
+	case "PUT":
+		err := h.fs.Mkdir("/" + bucket)
+		if os.IsExist(err) {
+			writeError(w, r, errBucketExists)
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.Header().Set("Location", "/"+bucket)
+	case "DELETE":
+		err := h.checkBucket(bucket)
+		if err == nil {
+			err = h.fs.Remove("/" + bucket)
+		}
+		if errors.Is(err, syscall.ENOTEMPTY) {
+			err = errBucketNotEmpty
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, errMethodNotAllowed)
+	}
+}
+
+func (h *Handler) checkBucket(bucket string) error {
+	fi, err := h.fs.Stat("/" + bucket)
+	if os.IsNotExist(err) || (err == nil && !fi.IsDir()) {
+		return errNoSuchBucket
+	}
+	return err
+}
+
+func (h *Handler) serveObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	err := h.checkBucket(bucket)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	q := r.URL.Query()
+	name := path.Join("/", bucket, key)
+	switch {
+	case r.Method == "POST" && q.Has("uploads"):
+		h.createUpload(w, r, bucket, key)
+	case r.Method == "PUT" && q.Has("uploadId"):
+		h.uploadPart(w, r, name, q.Get("uploadId"), q.Get("partNumber"))
+	case r.Method == "POST" && q.Has("uploadId"):
+		h.completeUpload(w, r, bucket, key, q.Get("uploadId"))
+	case r.Method == "DELETE" && q.Has("uploadId"):
+		h.abortUpload(w, r, name, q.Get("uploadId"))
+	case r.Method == "GET" || r.Method == "HEAD":
+		h.getObject(w, r, name)
+	case r.Method == "PUT":
+		h.putObject(w, r, bucket, name)
+	case r.Method == "DELETE":
+		h.deleteObject(w, r, bucket, name)
+	default:
+		writeError(w, r, errMethodNotAllowed)
+	}
+}
+
+// objectETag returns the MD5 based entity tag stored for an object, or the
+// boltfs entity tag for files written by other means.
+func objectETag(fi os.FileInfo) string {
+	meta, ok := fi.Sys().(*boltfs.FileMeta)
+	if !ok {
+		return ""
+	}
+	if etag, ok := meta.Xattrs[etagXattr]; ok {
+		return string(etag)
+	}
+	return meta.ETag
+}
+
+func (h *Handler) getObject(w http.ResponseWriter, r *http.Request, name string) {
+	rd, err := boltfs.NewReader(h.fs, name)
+	if err != nil {
+		if errors.Is(err, syscall.EISDIR) {
+			err = errNoSuchKey
+		}
+		writeError(w, r, err)
+		return
+	}
+	fi := rd.Stat()
+	hdr := w.Header()
+	hdr.Set("ETag", objectETag(fi))
+	if meta, ok := fi.Sys().(*boltfs.FileMeta); ok {
+		if meta.ContentType != "" {
+			hdr.Set("Content-Type", meta.ContentType)
+		} else {
+			hdr.Set("Content-Type", "binary/octet-stream")
+		}
+		if meta.ContentEncoding != "" {
+			hdr.Set("Content-Encoding", meta.ContentEncoding)
+		}
+		for k, v := range meta.Xattrs {
+			if strings.HasPrefix(k, metaXattrPrefix) {
+				hdr.Set("X-Amz-Meta-"+strings.TrimPrefix(k, metaXattrPrefix), string(v))
+			}
+		}
+	}
+	http.ServeContent(w, r, "", fi.ModTime(), rd)
+}
+
+func (h *Handler) putObject(w http.ResponseWriter, r *http.Request, bucket, name string) {
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		writeError(w, r, errNotImplemented)
+		return
+	}
+	xattrs := make(map[string][]byte)
+	for k, v := range r.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-meta-") && len(v) > 0 {
+			xattrs[metaXattrPrefix+strings.TrimPrefix(lk, "x-amz-meta-")] = []byte(v[0])
+		}
+	}
+	etag, err := h.store(h.fs, r, name, &boltfs.CreateOptions{
+		ContentType:     r.Header.Get("Content-Type"),
+		ContentEncoding: contentEncoding(r),
+		Xattrs:          xattrs,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("ETag", etag)
+}
+
+// store writes the request body to name in fs and records its MD5 entity
+// tag.
+func (h *Handler) store(fs boltfs.FileSystem, r *http.Request, name string, opts *boltfs.CreateOptions) (string, error) {
+	body, err := requestBody(r)
+	if err != nil {
+		return "", err
+	}
+	max := h.opts.MaxObjectSize
+	if max > 0 {
+		if r.ContentLength > max {
+			return "", errTooLarge
+		}
+		body = io.LimitReader(body, max+1)
+	}
+
+	wc, err := fs.CreateWithOptions(name, opts)
+	if err != nil {
+		return "", err
+	}
+	sum := md5.New()
+	n, err := io.Copy(io.MultiWriter(wc, sum), body)
+	if err == nil && max > 0 && n > max {
+		err = errTooLarge
+	}
+	if err != nil {
+		if a, ok := wc.(boltfs.Aborter); ok {
+			a.Abort()
+		}
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(sum.Sum(nil)) + `"`
+	return etag, closeWithETag(fs, wc, name, etag)
+}
+
+// closeWithETag publishes wc as name in fs along with its entity tag, in
+// the same transaction if the writer supports it.
+func closeWithETag(fs boltfs.FileSystem, wc io.WriteCloser, name, etag string) error {
+	xs, ok := wc.(boltfs.XattrSetter)
+	if !ok {
+		err := wc.Close()
+		if err != nil {
+			return err
+		}
+		return fs.SetXattr(name, etagXattr, []byte(etag))
+	}
+	err := xs.SetXattr(etagXattr, []byte(etag))
+	if err != nil {
+		if a, ok := wc.(boltfs.Aborter); ok {
+			a.Abort()
+		}
+		return err
+	}
+	return wc.Close()
+}
+
+// contentEncoding strips the aws-chunked transfer encoding, which describes
+// the request body rather than the object.
+func contentEncoding(r *http.Request) string {
+	var encodings []string
+	for _, enc := range strings.Split(r.Header.Get("Content-Encoding"), ",") {
+		enc = strings.TrimSpace(enc)
+		if enc != "" && enc != "aws-chunked" {
+			encodings = append(encodings, enc)
+		}
+	}
+	return strings.Join(encodings, ",")
+}
+
+func (h *Handler) deleteObject(w http.ResponseWriter, r *http.Request, bucket, name string) {
+	fi, err := h.fs.Stat(name)
+	if err == nil && !fi.IsDir() {
+		err = h.fs.Remove(name)
+		if err != nil && !os.IsNotExist(err) {
+			writeError(w, r, err)
+			return
+		}
+		h.removeEmptyParents(bucket, name)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeEmptyParents removes directories left empty by a delete, so they do
+// not show up as common prefixes.
+func (h *Handler) removeEmptyParents(bucket, name string) {
+	root := "/" + bucket
+	for dir := path.Dir(name); dir != root && dir != "/"; dir = path.Dir(dir) {
+		if h.fs.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+type bucketEntry struct {
+	Name         string
+	CreationDate string
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Owner   struct{ ID string }
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+func (h *Handler) listBuckets(w http.ResponseWriter, r *http.Request) {
+	f, err := h.fs.Open("/")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	inf, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	res := listAllMyBucketsResult{Xmlns: xmlns}
+	res.Owner.ID = "boltfs"
+	for _, fi := range inf {
+		if fi.IsDir() && validBucket(fi.Name()) {
+			res.Buckets = append(res.Buckets, bucketEntry{Name: fi.Name(), CreationDate: formatTime(fi.ModTime())})
+		}
+	}
+	writeXML(w, res)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
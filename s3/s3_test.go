@@ -0,0 +1,239 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/boltdb/bolt"
+	"github.com/mastercactapus/boltfs"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func apiCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+func TestS3(t *testing.T) {
+	Convey("When serving S3 requests", t, func() {
+		os.Remove("s3_test.db")
+		defer os.Remove("s3_test.db")
+		db, err := bolt.Open("s3_test.db", 0644, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fs, err := boltfs.NewFileSystem(boltfs.NewBoltDB(db), boltfs.NewBucketPath([]byte("test")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := httptest.NewServer(NewHandler(fs, nil))
+		defer srv.Close()
+
+		ctx := context.Background()
+		client := s3.New(s3.Options{
+			Region:       "us-east-1",
+			BaseEndpoint: aws.String(srv.URL),
+			UsePathStyle: true,
+			Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+				return aws.Credentials{AccessKeyID: "key", SecretAccessKey: "secret"}, nil
+			}),
+		})
+		put := func(key, data string) *s3.PutObjectOutput {
+			out, err := client.PutObject(ctx, &s3.PutObjectInput{
+				Bucket: aws.String("bucket"),
+				Key:    aws.String(key),
+				Body:   strings.NewReader(data),
+			})
+			So(err, ShouldBeNil)
+			return out
+		}
+		_, err = client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String("bucket")})
+		So(err, ShouldBeNil)
+
+		Convey("Should manage buckets", func() {
+			out, err := client.ListBuckets(ctx, &s3.ListBucketsInput{})
+			So(err, ShouldBeNil)
+			So(out.Buckets, ShouldHaveLength, 1)
+			So(*out.Buckets[0].Name, ShouldEqual, "bucket")
+
+			_, err = client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String("missing")})
+			So(err, ShouldNotBeNil)
+
+			put("key", "data")
+			_, err = client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String("bucket")})
+			So(apiCode(err), ShouldEqual, "BucketNotEmpty")
+		})
+		Convey("Should put, get and head objects", func() {
+			out := put("dir/hello.txt", "hello world")
+			So(*out.ETag, ShouldEqual, fmt.Sprintf(`"%x"`, md5.Sum([]byte("hello world"))))
+
+			_, err := client.PutObject(ctx, &s3.PutObjectInput{
+				Bucket:      aws.String("bucket"),
+				Key:         aws.String("meta"),
+				Body:        strings.NewReader("x"),
+				ContentType: aws.String("text/plain"),
+				Metadata:    map[string]string{"color": "blue"},
+			})
+			So(err, ShouldBeNil)
+			head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("meta")})
+			So(err, ShouldBeNil)
+			So(*head.ContentType, ShouldEqual, "text/plain")
+			So(head.Metadata["color"], ShouldEqual, "blue")
+			So(*head.ContentLength, ShouldEqual, 1)
+
+			get, err := client.GetObject(ctx, &s3.GetObjectInput{
+				Bucket: aws.String("bucket"),
+				Key:    aws.String("dir/hello.txt"),
+				Range:  aws.String("bytes=6-"),
+			})
+			So(err, ShouldBeNil)
+			data, _ := ioutil.ReadAll(get.Body)
+			get.Body.Close()
+			So(string(data), ShouldEqual, "world")
+			So(*get.ETag, ShouldEqual, *out.ETag)
+
+			_, err = client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("dir")})
+			So(apiCode(err), ShouldEqual, "NoSuchKey")
+		})
+		Convey("Should delete objects and their empty directories", func() {
+			put("a/b/c", "data")
+			_, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String("bucket"), Key: aws.String("a/b/c")})
+			So(err, ShouldBeNil)
+			_, err = fs.Stat("/bucket/a")
+			So(os.IsNotExist(err), ShouldBeTrue)
+			_, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String("bucket"), Key: aws.String("a/b/c")})
+			So(err, ShouldBeNil)
+		})
+		Convey("Should list objects", func() {
+			for _, key := range []string{"a/1", "a/2", "b", "c/d/e", "c/f", "d"} {
+				put(key, key)
+			}
+			list := func(in *s3.ListObjectsV2Input) (keys []string, token *string) {
+				in.Bucket = aws.String("bucket")
+				out, err := client.ListObjectsV2(ctx, in)
+				So(err, ShouldBeNil)
+				for _, p := range out.CommonPrefixes {
+					keys = append(keys, *p.Prefix)
+				}
+				for _, obj := range out.Contents {
+					keys = append(keys, *obj.Key)
+				}
+				return keys, out.NextContinuationToken
+			}
+			keys, _ := list(&s3.ListObjectsV2Input{})
+			So(keys, ShouldResemble, []string{"a/1", "a/2", "b", "c/d/e", "c/f", "d"})
+
+			keys, _ = list(&s3.ListObjectsV2Input{Prefix: aws.String("c/")})
+			So(keys, ShouldResemble, []string{"c/d/e", "c/f"})
+
+			keys, _ = list(&s3.ListObjectsV2Input{Delimiter: aws.String("/")})
+			So(keys, ShouldResemble, []string{"a/", "c/", "b", "d"})
+
+			keys, token := list(&s3.ListObjectsV2Input{Delimiter: aws.String("/"), MaxKeys: aws.Int32(2)})
+			So(keys, ShouldResemble, []string{"a/", "b"})
+			keys, token = list(&s3.ListObjectsV2Input{Delimiter: aws.String("/"), MaxKeys: aws.Int32(2), ContinuationToken: token})
+			So(keys, ShouldResemble, []string{"c/", "d"})
+			So(token, ShouldBeNil)
+
+			keys, _ = list(&s3.ListObjectsV2Input{StartAfter: aws.String("b")})
+			So(keys, ShouldResemble, []string{"c/d/e", "c/f", "d"})
+		})
+		Convey("Should complete multipart uploads", func() {
+			create, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+				Bucket:      aws.String("bucket"),
+				Key:         aws.String("big"),
+				ContentType: aws.String("application/x-test"),
+			})
+			So(err, ShouldBeNil)
+			part1 := bytes.Repeat([]byte("a"), 100000)
+			part2 := []byte("tail")
+			var parts []types.CompletedPart
+			for i, data := range [][]byte{part1, part2} {
+				out, err := client.UploadPart(ctx, &s3.UploadPartInput{
+					Bucket:     aws.String("bucket"),
+					Key:        aws.String("big"),
+					UploadId:   create.UploadId,
+					PartNumber: aws.Int32(int32(i + 1)),
+					Body:       bytes.NewReader(data),
+				})
+				So(err, ShouldBeNil)
+				parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(int32(i + 1))})
+			}
+			f, err := fs.Open("/")
+			So(err, ShouldBeNil)
+			entries, err := f.Readdir(-1)
+			f.Close()
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+			report, err := fs.Fsck(false)
+			So(err, ShouldBeNil)
+			So(report.Orphans, ShouldEqual, 0)
+
+			done, err := client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+				Bucket:          aws.String("bucket"),
+				Key:             aws.String("big"),
+				UploadId:        create.UploadId,
+				MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+			})
+			So(err, ShouldBeNil)
+			So(*done.ETag, ShouldEndWith, `-2"`)
+
+			get, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String("bucket"), Key: aws.String("big")})
+			So(err, ShouldBeNil)
+			data, _ := ioutil.ReadAll(get.Body)
+			get.Body.Close()
+			So(bytes.Equal(data, append(part1, part2...)), ShouldBeTrue)
+			So(*get.ContentType, ShouldEqual, "application/x-test")
+			So(*get.ETag, ShouldEqual, *done.ETag)
+
+			staging, err := fs.Staging()
+			So(err, ShouldBeNil)
+			_, err = staging.Stat("/" + *create.UploadId)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+		Convey("Should abort multipart uploads", func() {
+			create, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+				Bucket: aws.String("bucket"),
+				Key:    aws.String("big"),
+			})
+			So(err, ShouldBeNil)
+			_, err = client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String("bucket"),
+				Key:      aws.String("big"),
+				UploadId: create.UploadId,
+			})
+			So(err, ShouldBeNil)
+			_, err = client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String("bucket"),
+				Key:      aws.String("big"),
+				UploadId: create.UploadId,
+			})
+			So(apiCode(err), ShouldEqual, "NoSuchUpload")
+		})
+		Convey("Should deny unauthorized requests", func() {
+			h := NewHandler(fs, &Options{Authorize: func(r *http.Request) error {
+				return errors.New("denied")
+			}})
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/bucket?list-type=2", nil))
+			So(rec.Code, ShouldEqual, http.StatusForbidden)
+			So(rec.Body.String(), ShouldContainSubstring, "AccessDenied")
+		})
+	})
+}
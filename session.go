@@ -0,0 +1,82 @@
+package boltfs
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Session is a writer whose progress is stored in bolt, so it can be
+// resumed with ResumeSession after the process restarts. Each Write is
+// committed before it returns, so wrap it in a bufio.Writer when writing
+// small pieces. The file is published atomically on Close.
+type Session struct {
+	fs     *boltFs
+	id     string
+	offset int64
+	closed bool
+}
+
+// CreateSession starts a session writing to name and returns its id.
+func (fs *boltFs) CreateSession(name string, opts *CreateOptions) (string, error) {
+	return fs.createUpload(name, -1, opts)
+}
+
+// ResumeSession returns a Session that continues writing after the last
+// committed Write of the session id.
+func (fs *boltFs) ResumeSession(id string) (*Session, error) {
+	info, err := fs.StatUpload(id)
+	if err != nil {
+		return nil, err
+	}
+	if info.Length >= 0 {
+		return nil, fmt.Errorf("resume %s: not a session", id)
+	}
+	return &Session{fs: fs, id: id, offset: info.Offset}, nil
+}
+
+// ID returns the id used to resume the session.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Offset returns the number of bytes committed so far.
+func (s *Session) Offset() int64 {
+	return s.offset
+}
+
+func (s *Session) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, fmt.Errorf("session is closed")
+	}
+	offset, err := s.fs.WriteUpload(s.id, s.offset, bytes.NewReader(p))
+	n := int(offset - s.offset)
+	s.offset = offset
+	return n, err
+}
+
+// Close publishes the file and ends the session.
+func (s *Session) Close() error {
+	if s.closed {
+		return fmt.Errorf("session is closed")
+	}
+	s.closed = true
+	return s.fs.db.Update(func(tx Transaction) error {
+		rec, err := s.fs.loadUpload(tx, s.id)
+		if err != nil {
+			return err
+		}
+		if rec.Offset != s.offset {
+			return fmt.Errorf("session %s: %w: at %d not %d", s.id, ErrUploadOffset, rec.Offset, s.offset)
+		}
+		return s.fs.finishUpload(tx, s.id, rec)
+	})
+}
+
+// Abort ends the session and discards everything written to it.
+func (s *Session) Abort() error {
+	if s.closed {
+		return fmt.Errorf("session is closed")
+	}
+	s.closed = true
+	return s.fs.RemoveUpload(s.id)
+}
@@ -0,0 +1,75 @@
+package boltfs
+
+import (
+	"errors"
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	Convey("When writing with a session", t, func() {
+		os.Remove("session_test.db")
+		defer os.Remove("session_test.db")
+		db, err := bolt.Open("session_test.db", 0644, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fs, err := NewFileSystem(NewBoltDB(db), NewBucketPath([]byte("test")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := fs.CreateSession("log", &CreateOptions{ContentType: "text/plain"})
+		So(err, ShouldBeNil)
+		s, err := fs.ResumeSession(id)
+		So(err, ShouldBeNil)
+		So(s.ID(), ShouldEqual, id)
+
+		Convey("Should continue after a restart", func() {
+			_, err := io.WriteString(s, "first ")
+			So(err, ShouldBeNil)
+
+			// the process crashes without closing s
+			fs, err = NewFileSystem(NewBoltDB(db), NewBucketPath([]byte("test")))
+			So(err, ShouldBeNil)
+			s, err = fs.ResumeSession(id)
+			So(err, ShouldBeNil)
+			So(s.Offset(), ShouldEqual, 6)
+			io.WriteString(s, "second")
+			So(s.Close(), ShouldBeNil)
+
+			f, err := fs.Open("log")
+			So(err, ShouldBeNil)
+			data, _ := ioutil.ReadAll(f)
+			f.Close()
+			So(string(data), ShouldEqual, "first second")
+			_, err = fs.ResumeSession(id)
+			So(errors.Is(err, ErrNoUpload), ShouldBeTrue)
+		})
+		Convey("Should fail when resumed twice", func() {
+			other, err := fs.ResumeSession(id)
+			So(err, ShouldBeNil)
+			io.WriteString(other, "other")
+			_, err = io.WriteString(s, "stale")
+			So(errors.Is(err, ErrUploadOffset), ShouldBeTrue)
+		})
+		Convey("Should discard aborted sessions", func() {
+			io.WriteString(s, "data")
+			So(s.Abort(), ShouldBeNil)
+			_, err := fs.Stat("log")
+			So(os.IsNotExist(err), ShouldBeTrue)
+			_, err = fs.StatUpload(id)
+			So(errors.Is(err, ErrNoUpload), ShouldBeTrue)
+		})
+		Convey("Should not resume fixed length uploads", func() {
+			id, err := fs.CreateUpload("fixed", 10, nil)
+			So(err, ShouldBeNil)
+			_, err = fs.ResumeSession(id)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,223 @@
+// Package sftpfs serves a boltfs FileSystem through the request server of
+// github.com/pkg/sftp.
+package sftpfs
+
+import (
+	"fmt"
+	"github.com/mastercactapus/boltfs"
+	"github.com/pkg/sftp"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// maxPending limits how much out of order data an upload will buffer.
+const maxPending = 64 << 20
+
+type handler struct {
+	fs boltfs.FileSystem
+}
+
+// Handlers returns sftp request server handlers backed by fs.
+//
+// Uploads are published atomically when the client closes the file and
+// discarded if the transfer fails. Writes may arrive out of order, but an
+// existing file can only be appended to, not modified in place.
+func Handlers(fs boltfs.FileSystem) sftp.Handlers {
+	h := &handler{fs: fs}
+	return sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h}
+}
+
+func (h *handler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	rd, err := boltfs.NewReader(h.fs, r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
+func (h *handler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	flags := r.Pflags()
+	fi, err := h.fs.Stat(r.Filepath)
+	exists := err == nil
+	switch {
+	case err != nil && !os.IsNotExist(err):
+		return nil, err
+	case exists && fi.IsDir():
+		return nil, &os.PathError{Op: "open", Path: r.Filepath, Err: syscall.EISDIR}
+	case exists && flags.Creat && flags.Excl:
+		return nil, &os.PathError{Op: "open", Path: r.Filepath, Err: os.ErrExist}
+	case !exists && !flags.Creat:
+		return nil, &os.PathError{Op: "open", Path: r.Filepath, Err: os.ErrNotExist}
+	}
+
+	wc, err := h.fs.Create(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	w := &writer{wc: wc, pending: make(map[int64][]byte)}
+	if exists && !flags.Trunc {
+		var rd *boltfs.Reader
+		rd, err = boltfs.NewReader(h.fs, r.Filepath)
+		if err == nil {
+			_, err = io.Copy(w.wc, rd)
+		}
+		if err != nil {
+			w.TransferError(err)
+			return nil, err
+		}
+		w.offset = rd.Stat().Size()
+		w.base = w.offset
+	}
+	return w, nil
+}
+
+func (h *handler) Filecmd(r *sftp.Request) error {
+	switch r.Method {
+	case "Setstat":
+		return nil
+	case "Rename":
+		return h.fs.Rename(r.Filepath, r.Target)
+	case "Mkdir":
+		return h.fs.Mkdir(r.Filepath)
+	case "Rmdir", "Remove":
+		fi, err := h.fs.Stat(r.Filepath)
+		if err != nil {
+			return err
+		}
+		if r.Method == "Rmdir" && !fi.IsDir() {
+			return &os.PathError{Op: "rmdir", Path: r.Filepath, Err: syscall.ENOTDIR}
+		}
+		if r.Method == "Remove" && fi.IsDir() {
+			return &os.PathError{Op: "remove", Path: r.Filepath, Err: syscall.EISDIR}
+		}
+		return h.fs.Remove(r.Filepath)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (h *handler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		f, err := h.fs.Open(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		inf, err := f.Readdir(-1)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt(inf), nil
+	case "Stat":
+		fi, err := h.fs.Stat(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{fi}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(ls []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// writer reorders WriteAt calls into the sequential writes boltfs needs.
+type writer struct {
+	mu      sync.Mutex
+	wc      io.WriteCloser
+	base    int64
+	offset  int64
+	pending map[int64][]byte
+	size    int
+	err     error
+}
+
+func (w *writer) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	if off < w.offset {
+		if off < w.base {
+			w.err = fmt.Errorf("write at %d: existing data cannot be modified", off)
+		} else {
+			w.err = fmt.Errorf("write at %d: data already written", off)
+		}
+		return 0, w.err
+	}
+	if off > w.offset {
+		if w.size+len(p) > maxPending {
+			w.err = fmt.Errorf("write at %d: too much out of order data", off)
+			return 0, w.err
+		}
+		w.pending[off] = append([]byte{}, p...)
+		w.size += len(p)
+		return len(p), nil
+	}
+
+	_, err := w.wc.Write(p)
+	if err != nil {
+		w.err = err
+		return 0, err
+	}
+	w.offset += int64(len(p))
+	for {
+		next, ok := w.pending[w.offset]
+		if !ok {
+			break
+		}
+		delete(w.pending, w.offset)
+		w.size -= len(next)
+		_, err = w.wc.Write(next)
+		if err != nil {
+			w.err = err
+			return len(p), nil
+		}
+		w.offset += int64(len(next))
+	}
+	return len(p), nil
+}
+
+// TransferError is called by the sftp server when a transfer fails, so the
+// upload is discarded instead of published.
+func (w *writer) TransferError(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil && len(w.pending) > 0 {
+		next := int64(-1)
+		for off := range w.pending {
+			if next == -1 || off < next {
+				next = off
+			}
+		}
+		w.err = fmt.Errorf("close: missing data between %d and %d", w.offset, next)
+	}
+	if w.err != nil {
+		if a, ok := w.wc.(boltfs.Aborter); ok {
+			a.Abort()
+		}
+		return w.err
+	}
+	return w.wc.Close()
+}
@@ -0,0 +1,153 @@
+package sftpfs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/boltdb/bolt"
+	"github.com/mastercactapus/boltfs"
+	"github.com/pkg/sftp"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+// serveSSH accepts SSH connections on l and runs the sftp subsystem for
+// each session.
+func serveSSH(l net.Listener, config *ssh.ServerConfig, handlers sftp.Handlers) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(conn, config)
+			if err != nil {
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+			for nc := range chans {
+				if nc.ChannelType() != "session" {
+					nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+					continue
+				}
+				ch, reqs, err := nc.Accept()
+				if err != nil {
+					continue
+				}
+				go func() {
+					for req := range reqs {
+						ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+						req.Reply(ok, nil)
+						if ok {
+							srv := sftp.NewRequestServer(ch, handlers)
+							srv.Serve()
+							srv.Close()
+							ch.Close()
+						}
+					}
+				}()
+			}
+		}()
+	}
+}
+
+func TestSFTP(t *testing.T) {
+	Convey("When serving boltfs over SFTP", t, func() {
+		os.Remove("sftpfs_test.db")
+		defer os.Remove("sftpfs_test.db")
+		db, err := bolt.Open("sftpfs_test.db", 0644, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fs, err := boltfs.NewFileSystem(boltfs.NewBoltDB(db), boltfs.NewBucketPath([]byte("test")))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := &ssh.ServerConfig{NoClientAuth: true}
+		config.AddHostKey(signer)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		go serveSSH(l, config, Handlers(fs))
+
+		conn, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+			User:            "test",
+			HostKeyCallback: ssh.FixedHostKey(signer.PublicKey()),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		client, err := sftp.NewClient(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		put := func(name, data string) {
+			f, err := client.Create(name)
+			So(err, ShouldBeNil)
+			_, err = io.WriteString(f, data)
+			So(err, ShouldBeNil)
+			So(f.Close(), ShouldBeNil)
+		}
+		get := func(name string) string {
+			f, err := client.Open(name)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			data, err := ioutil.ReadAll(f)
+			So(err, ShouldBeNil)
+			return string(data)
+		}
+
+		Convey("Should upload and download files", func() {
+			So(client.Mkdir("/in"), ShouldBeNil)
+			put("/in/a.txt", "hello over sftp")
+			So(get("/in/a.txt"), ShouldEqual, "hello over sftp")
+
+			fi, err := client.Stat("/in/a.txt")
+			So(err, ShouldBeNil)
+			So(fi.Size(), ShouldEqual, 15)
+
+			inf, err := client.ReadDir("/in")
+			So(err, ShouldBeNil)
+			So(len(inf), ShouldEqual, 1)
+			So(inf[0].Name(), ShouldEqual, "a.txt")
+		})
+		Convey("Should upload large files written concurrently", func() {
+			data := make([]byte, 1<<20)
+			rand.Read(data)
+			f, err := client.Create("/big")
+			So(err, ShouldBeNil)
+			_, err = f.ReadFrom(bytes.NewReader(data))
+			So(err, ShouldBeNil)
+			So(f.Close(), ShouldBeNil)
+			So(get("/big") == string(data), ShouldBeTrue)
+		})
+		Convey("Should rename and remove files", func() {
+			put("/a.txt", "hello")
+			So(client.Rename("/a.txt", "/b.txt"), ShouldBeNil)
+			So(get("/b.txt"), ShouldEqual, "hello")
+			So(client.Remove("/b.txt"), ShouldBeNil)
+			_, err := client.Stat("/b.txt")
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,338 @@
+package boltfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gopkg.in/vmihailenco/msgpack.v2"
+	"net/http"
+	"os"
+	"time"
+)
+
+const snapshotsKey = "snapshots"
+
+// refsKey holds the reference counts of inodes shared by snapshots. An
+// inode without a count has a single reference.
+const refsKey = "refs"
+
+// Snapshots are kept in a bucket for every root, named by rootKey, so
+// every FileSystem has its own snapshot names. Every snapshot is a bucket
+// holding its record and a copy of the namespace.
+const (
+	snapshotInfoKey = "info"
+	snapshotTreeKey = "tree"
+)
+
+// ErrNoSnapshot is returned for an unknown snapshot name.
+var ErrNoSnapshot = errors.New("no such snapshot")
+
+type snapshotRecord struct {
+	// Root is the namespace bucket the snapshot was taken of.
+	Root    BucketPath
+	Created time.Time
+
+	// Usage is the data held by the snapshot, which is charged to Root
+	// until the snapshot is deleted.
+	Usage Usage
+}
+
+// SnapshotInfo describes a snapshot.
+type SnapshotInfo struct {
+	Name    string
+	Created time.Time
+}
+
+func refsPath(inode BucketPath) BucketPath {
+	return BucketPath(inode[:len(inode)-2]).Join([]byte(refsKey))
+}
+
+// addRef adds a reference to inode, for a snapshot sharing it.
+func addRef(tx Bucketer, inode BucketPath) error {
+	bk, err := refsPath(inode).MkFrom(tx)
+	if err != nil {
+		return err
+	}
+	id := inode[len(inode)-1]
+	n := uint64(1)
+	if v := bk.Get(id); len(v) == 8 {
+		n = binary.LittleEndian.Uint64(v)
+	}
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, n+1)
+	return bk.Put(id, buf)
+}
+
+// dropRef releases a reference to inode, reporting false if it was the
+// last one and the inode can be deleted.
+func dropRef(tx Bucketer, inode BucketPath) bool {
+	bk := refsPath(inode).BucketFrom(tx)
+	if bk == nil {
+		return false
+	}
+	id := inode[len(inode)-1]
+	v := bk.Get(id)
+	if len(v) != 8 {
+		return false
+	}
+	n := binary.LittleEndian.Uint64(v) - 1
+	if n <= 1 {
+		bk.Delete(id)
+		return true
+	}
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, n)
+	bk.Put(id, buf)
+	return true
+}
+
+// sharedInode reports if inode is referred to by a snapshot as well.
+func sharedInode(tx Bucketer, inode BucketPath) bool {
+	bk := refsPath(inode).BucketFrom(tx)
+	return bk != nil && len(bk.Get(inode[len(inode)-1])) == 8
+}
+
+// unshareInode gives the file stat, stored at key of bk, a copy of its
+// shared inode along with its xattrs, which can then be changed without
+// changing the snapshots that refer to the original.
+func (fs *boltFs) unshareInode(tx Transaction, bk Bucket, key []byte, stat *fileStat) error {
+	inode, err := fs.nextInodeTx(tx)
+	if err != nil {
+		return err
+	}
+	err = copyBucket(stat.Inode.BucketFrom(tx), inode.BucketFrom(tx))
+	if err != nil {
+		return err
+	}
+	if meta := metaPath(stat.Inode).BucketFrom(tx); meta != nil {
+		dst, err := metaPath(inode).CreateFrom(tx)
+		if err != nil {
+			return err
+		}
+		err = copyBucket(meta, dst)
+		if err != nil {
+			return err
+		}
+	}
+	deleteInode(tx, stat.Inode)
+	stat.Inode = inode
+	data, err := msgpack.Marshal(stat)
+	if err != nil {
+		return err
+	}
+	return bk.Put(key, data)
+}
+
+// walkInodes calls fn for every file and version below bk.
+func walkInodes(bk Bucket, fn func(stat *fileStat) error) error {
+	err := walkStats(bk, "", func(name string, stat *fileStat) error {
+		if stat.Dir || len(stat.Inode) == 0 {
+			return nil
+		}
+		return fn(stat)
+	})
+	if err != nil {
+		return err
+	}
+	return walkVersions(bk, fn)
+}
+
+func (fs *boltFs) snapshotsPath() BucketPath {
+	return fs.path.Join([]byte(snapshotsKey), rootKey(fs.path, fs.root))
+}
+
+// Snapshot saves the current state of every file and directory as the
+// snapshot name. It copies the namespace but shares the data of files,
+// which is kept until neither a file nor a snapshot refers to it. The
+// snapshot is charged all of the data it holds, shared or not, until it
+// is deleted. Changing the xattrs of a file copies its data first if a
+// snapshot shares it, since they are stored with the data.
+func (fs *boltFs) Snapshot(name string) error {
+	err := checkName(name)
+	if err == nil && name == "" {
+		err = os.ErrInvalid
+	}
+	if err != nil {
+		return &os.PathError{Op: "snapshot", Path: name, Err: err}
+	}
+	return fs.db.Update(func(tx Transaction) error {
+		bk, err := fs.snapshotsPath().MkFrom(tx)
+		if err != nil {
+			return err
+		}
+		if bk.Bucket([]byte(name)) != nil {
+			return &os.PathError{Op: "snapshot", Path: name, Err: os.ErrExist}
+		}
+		u, err := walkUsage(fs.root.BucketFrom(tx))
+		if err != nil {
+			return err
+		}
+		rec := snapshotRecord{Root: fs.root, Created: time.Now(), Usage: u.data()}
+		err = addUsage(tx, fs.root, rec.Usage)
+		if err != nil {
+			return err
+		}
+		snap, err := bk.CreateBucket([]byte(name))
+		if err != nil {
+			return err
+		}
+		data, err := msgpack.Marshal(&rec)
+		if err != nil {
+			return err
+		}
+		err = snap.Put([]byte(snapshotInfoKey), data)
+		if err != nil {
+			return err
+		}
+		tree, err := snap.CreateBucket([]byte(snapshotTreeKey))
+		if err != nil {
+			return err
+		}
+		err = copyBucket(fs.root.BucketFrom(tx), tree)
+		if err != nil {
+			return err
+		}
+		return walkInodes(tree, func(stat *fileStat) error {
+			return addRef(tx, stat.Inode)
+		})
+	})
+}
+
+// loadSnapshot returns the bucket of the snapshot name, or nil if it does
+// not exist.
+func (fs *boltFs) loadSnapshot(tx Transaction, name string) (Bucket, *snapshotRecord, error) {
+	bk := fs.snapshotsPath().BucketFrom(tx)
+	if bk == nil || bk.Bucket([]byte(name)) == nil {
+		return nil, nil, nil
+	}
+	snap := bk.Bucket([]byte(name))
+	var rec snapshotRecord
+	err := msgpack.Unmarshal(snap.Get([]byte(snapshotInfoKey)), &rec)
+	if err != nil {
+		return nil, nil, err
+	}
+	return snap, &rec, nil
+}
+
+// ListSnapshots returns the snapshots of the FileSystem, sorted by name.
+func (fs *boltFs) ListSnapshots() ([]SnapshotInfo, error) {
+	var snaps []SnapshotInfo
+	err := fs.db.View(func(tx Transaction) error {
+		bk := fs.snapshotsPath().BucketFrom(tx)
+		if bk == nil {
+			return nil
+		}
+		c := bk.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			snap, rec, err := fs.loadSnapshot(tx, string(k))
+			if err != nil {
+				return err
+			}
+			if snap != nil {
+				snaps = append(snaps, SnapshotInfo{Name: string(k), Created: rec.Created})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snaps, nil
+}
+
+// OpenSnapshot returns a read-only view of the snapshot name.
+func (fs *boltFs) OpenSnapshot(name string) (http.FileSystem, error) {
+	var root BucketPath
+	err := fs.db.View(func(tx Transaction) error {
+		snap, _, err := fs.loadSnapshot(tx, name)
+		if err != nil {
+			return err
+		}
+		if snap == nil {
+			return fmt.Errorf("open snapshot %s: %w", name, ErrNoSnapshot)
+		}
+		root = fs.snapshotsPath().Join([]byte(name), []byte(snapshotTreeKey))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshotFS{&boltFs{db: fs.db, path: fs.path, root: root, opts: fs.opts}}, nil
+}
+
+// DeleteSnapshot deletes the snapshot name, along with the data of files
+// that are no longer referred to.
+func (fs *boltFs) DeleteSnapshot(name string) error {
+	return fs.db.Update(func(tx Transaction) error {
+		snap, rec, err := fs.loadSnapshot(tx, name)
+		if err != nil {
+			return err
+		}
+		if snap == nil {
+			return fmt.Errorf("delete snapshot %s: %w", name, ErrNoSnapshot)
+		}
+		err = releaseUsage(tx, rec.Root, rec.Usage)
+		if err != nil {
+			return err
+		}
+		err = walkInodes(snap.Bucket([]byte(snapshotTreeKey)), func(stat *fileStat) error {
+			deleteInode(tx, stat.Inode)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return fs.snapshotsPath().BucketFrom(tx).DeleteBucket([]byte(name))
+	})
+}
+
+// walkSnapshots calls fn for every file and version kept by a snapshot of
+// any FileSystem of the store.
+func (fs *boltFs) walkSnapshots(tx Transaction, fn func(stat *fileStat) error) error {
+	bk := fs.path.Join([]byte(snapshotsKey)).BucketFrom(tx)
+	c := bk.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		roots := bk.Bucket(k)
+		rc := roots.Cursor()
+		for name, _ := rc.First(); name != nil; name, _ = rc.Next() {
+			err := walkInodes(roots.Bucket(name).Bucket([]byte(snapshotTreeKey)), fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// snapshotUsage returns the data charged to p and the directories below it
+// for the snapshots they took.
+func (fs *boltFs) snapshotUsage(tx Transaction, p BucketPath) (Usage, error) {
+	var u Usage
+	bk := fs.path.Join([]byte(snapshotsKey)).BucketFrom(tx)
+	c := bk.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		roots := bk.Bucket(k)
+		rc := roots.Cursor()
+		for name, _ := rc.First(); name != nil; name, _ = rc.Next() {
+			var rec snapshotRecord
+			err := msgpack.Unmarshal(roots.Bucket(name).Get([]byte(snapshotInfoKey)), &rec)
+			if err != nil {
+				return u, err
+			}
+			if isSubPath(p, rec.Root) && rec.Root.BucketFrom(tx) != nil {
+				u.add(rec.Usage)
+			}
+		}
+	}
+	return u, nil
+}
+
+// snapshotFS serves a snapshot without exposing the methods that change a
+// FileSystem.
+type snapshotFS struct {
+	fs *boltFs
+}
+
+func (s snapshotFS) Open(name string) (http.File, error) {
+	return s.fs.Open(name)
+}
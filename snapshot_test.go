@@ -0,0 +1,144 @@
+package boltfs
+
+import (
+	"errors"
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	Convey("When taking snapshots", t, func() {
+		db, fs, done := openTestFS(t, "snapshot", &Options{Retention: Retention{Versions: 1}})
+		defer done()
+		orphans := func() int {
+			report, err := fs.Fsck(false)
+			So(err, ShouldBeNil)
+			So(report.Problems, ShouldBeEmpty)
+			return report.Orphans
+		}
+		writeFile(fs, "dir/a", "one")
+		writeFile(fs, "dir/a", "two")
+		writeFile(fs, "b", "bee")
+		So(fs.Snapshot("first"), ShouldBeNil)
+
+		Convey("Should keep files as they were", func() {
+			writeFile(fs, "dir/a", "three")
+			So(fs.Remove("b"), ShouldBeNil)
+			So(fs.RemoveAll("dir"), ShouldBeNil)
+			So(orphans(), ShouldEqual, 0)
+
+			snap, err := fs.OpenSnapshot("first")
+			So(err, ShouldBeNil)
+			So(readFile(snap, "dir/a"), ShouldEqual, "two")
+			So(readFile(snap, "b"), ShouldEqual, "bee")
+			f, err := snap.Open("dir")
+			So(err, ShouldBeNil)
+			fis, err := f.Readdir(-1)
+			f.Close()
+			So(err, ShouldBeNil)
+			So(len(fis), ShouldEqual, 1)
+			_, err = snap.Open("c")
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+		Convey("Should list snapshots", func() {
+			So(fs.Snapshot("second"), ShouldBeNil)
+			So(os.IsExist(fs.Snapshot("second")), ShouldBeTrue)
+			So(fs.Snapshot(""), ShouldNotBeNil)
+
+			other, err := NewFileSystem(NewBoltDB(db), NewBucketPath([]byte("test")))
+			So(err, ShouldBeNil)
+			sub, err := other.Sub("dir")
+			So(err, ShouldBeNil)
+			So(sub.Snapshot("sub"), ShouldBeNil)
+
+			snaps, err := fs.ListSnapshots()
+			So(err, ShouldBeNil)
+			So(len(snaps), ShouldEqual, 2)
+			So(snaps[0].Name, ShouldEqual, "first")
+			So(snaps[1].Name, ShouldEqual, "second")
+			So(snaps[0].Created.IsZero(), ShouldBeFalse)
+
+			snaps, err = sub.ListSnapshots()
+			So(err, ShouldBeNil)
+			So(len(snaps), ShouldEqual, 1)
+			_, err = fs.OpenSnapshot("sub")
+			So(errors.Is(err, ErrNoSnapshot), ShouldBeTrue)
+
+			// every FileSystem has its own snapshot names
+			So(sub.Snapshot("second"), ShouldBeNil)
+			So(fs.DeleteSnapshot("second"), ShouldBeNil)
+			snap, err := sub.OpenSnapshot("second")
+			So(err, ShouldBeNil)
+			So(readFile(snap, "a"), ShouldEqual, "two")
+		})
+		Convey("Should not share xattrs changed after a snapshot", func() {
+			So(fs.SetXattr("b", "color", []byte("red")), ShouldBeNil)
+			snap, err := fs.OpenSnapshot("first")
+			So(err, ShouldBeNil)
+			f, err := snap.Open("b")
+			So(err, ShouldBeNil)
+			fi, err := f.Stat()
+			f.Close()
+			So(err, ShouldBeNil)
+			So(fi.Sys().(*FileMeta).Xattrs, ShouldBeEmpty)
+			So(readFile(snap, "b"), ShouldEqual, "bee")
+
+			So(fs.SetXattr("b", "size", []byte("small")), ShouldBeNil)
+			So(fs.RemoveXattr("b", "color"), ShouldBeNil)
+			keys, err := fs.ListXattr("b")
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"size"})
+			So(readFile(fs, "b"), ShouldEqual, "bee")
+
+			So(fs.DeleteSnapshot("first"), ShouldBeNil)
+			So(orphans(), ShouldEqual, 0)
+		})
+		Convey("Should charge the data of snapshots to their root", func() {
+			u, err := fs.Usage("/")
+			So(err, ShouldBeNil)
+			So(*u, ShouldResemble, Usage{Bytes: 18, Files: 2, Directories: 1, Blocks: 6})
+			So(fs.DeleteSnapshot("first"), ShouldBeNil)
+			u, err = fs.Usage("/")
+			So(err, ShouldBeNil)
+			So(*u, ShouldResemble, Usage{Bytes: 9, Files: 2, Directories: 1, Blocks: 3})
+
+			counted, err := NewFileSystemWithOptions(NewBoltDB(db), NewBucketPath([]byte("counted")), &Options{Counters: true})
+			So(err, ShouldBeNil)
+			writeFile(counted, "f", "abc")
+			So(counted.Snapshot("s"), ShouldBeNil)
+			u, err = counted.Usage("/")
+			So(err, ShouldBeNil)
+			So(*u, ShouldResemble, Usage{Bytes: 6, Files: 1, Blocks: 2})
+			So(counted.DeleteSnapshot("s"), ShouldBeNil)
+			u, err = counted.Usage("/")
+			So(err, ShouldBeNil)
+			So(*u, ShouldResemble, Usage{Bytes: 3, Files: 1, Blocks: 1})
+		})
+		Convey("Should free data once no longer referred to", func() {
+			So(fs.Snapshot("second"), ShouldBeNil)
+			So(fs.RemoveAll("dir"), ShouldBeNil)
+			So(fs.DeleteSnapshot("first"), ShouldBeNil)
+			So(orphans(), ShouldEqual, 0)
+			snap, err := fs.OpenSnapshot("second")
+			So(err, ShouldBeNil)
+			So(readFile(snap, "dir/a"), ShouldEqual, "two")
+
+			So(fs.DeleteSnapshot("second"), ShouldBeNil)
+			So(orphans(), ShouldEqual, 0)
+			So(errors.Is(fs.DeleteSnapshot("second"), ErrNoSnapshot), ShouldBeTrue)
+			_, err = fs.OpenSnapshot("second")
+			So(errors.Is(err, ErrNoSnapshot), ShouldBeTrue)
+
+			var inodes int
+			So(db.View(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("test")).Bucket([]byte(inodesKey)).ForEach(func(k, v []byte) error {
+					inodes++
+					return nil
+				})
+			}), ShouldBeNil)
+			So(inodes, ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,35 @@
+package boltfs
+
+// stagingKey holds a staging area for every root, outside of the fs bucket.
+const stagingKey = "staging"
+
+// Staging returns a FileSystem for files that are not part of the
+// namespace yet, such as the parts of a multipart upload. It is stored
+// apart from the namespace, so its files are not listed, counted by Usage
+// or quotas, moved to the trash or saved by snapshots. Every FileSystem,
+// including those returned by Sub, has its own staging area.
+func (fs *boltFs) Staging() (FileSystem, error) {
+	root := fs.path.Join([]byte(stagingKey), rootKey(fs.path, fs.root))
+	err := fs.db.Update(func(tx Transaction) error {
+		_, err := root.MkFrom(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &boltFs{db: fs.db, path: fs.path, root: root}, nil
+}
+
+// walkStaging calls fn for every file staged by any FileSystem of the
+// store.
+func (fs *boltFs) walkStaging(tx Transaction, fn func(stat *fileStat) error) error {
+	bk := fs.path.Join([]byte(stagingKey)).BucketFrom(tx)
+	c := bk.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		err := walkInodes(bk.Bucket(k), fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
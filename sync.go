@@ -0,0 +1,364 @@
+package boltfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// SyncOptions control SyncFromDir and SyncToDir.
+type SyncOptions struct {
+	// Delete removes files and directories from the destination that do
+	// not exist in the source.
+	Delete bool
+
+	// DryRun reports what would change without changing anything.
+	DryRun bool
+
+	// Checksum compares content hashes instead of size and modification
+	// time.
+	Checksum bool
+}
+
+// SyncResult lists the changes made by a sync, as slash separated paths
+// relative to the destination.
+type SyncResult struct {
+	Copied  []string
+	Deleted []string
+}
+
+// SyncFromDir makes the boltfs directory fsPath match the local directory
+// localPath, copying only files that differ. Modification times are kept,
+// and content types are set from file extensions. Entries other than
+// regular files and directories are ignored.
+func SyncFromDir(fs FileSystem, localPath, fsPath string, opts *SyncOptions) (*SyncResult, error) {
+	if opts == nil {
+		opts = &SyncOptions{}
+	}
+	src, err := localTree(localPath)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := fsTree(fs, fsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	res := &SyncResult{}
+	if opts.Delete {
+		for _, rel := range deletions(src, dst) {
+			res.Deleted = append(res.Deleted, rel)
+			if !opts.DryRun {
+				err = fs.RemoveAll(path.Join(fsPath, rel))
+				if err != nil {
+					return res, err
+				}
+			}
+		}
+	}
+	if !opts.DryRun && dst == nil {
+		err = mkdirAll(fs, fsPath)
+		if err != nil {
+			return res, err
+		}
+	}
+	for _, rel := range sortedKeys(src) {
+		fi := src[rel]
+		name := path.Join(fsPath, rel)
+		local := filepath.Join(localPath, filepath.FromSlash(rel))
+		old, exists := dst[rel]
+		if exists && old.IsDir() == fi.IsDir() {
+			if fi.IsDir() {
+				continue
+			}
+			same, err := sameFile(fi, old, local, opts.Checksum)
+			if err != nil {
+				return res, err
+			}
+			if same {
+				continue
+			}
+		}
+		if !fi.IsDir() {
+			res.Copied = append(res.Copied, rel)
+		}
+		if opts.DryRun {
+			continue
+		}
+		if exists && old.IsDir() != fi.IsDir() {
+			err = fs.RemoveAll(name)
+			if err != nil {
+				return res, err
+			}
+		}
+		if fi.IsDir() {
+			err = fs.Mkdir(name)
+		} else {
+			err = copyToFS(fs, local, name, fi)
+		}
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// SyncToDir makes the local directory localPath match the boltfs directory
+// fsPath, copying only files that differ. Files are replaced by renaming a
+// temporary file into place, and modification times are kept.
+func SyncToDir(fs FileSystem, fsPath, localPath string, opts *SyncOptions) (*SyncResult, error) {
+	if opts == nil {
+		opts = &SyncOptions{}
+	}
+	src, err := fsTree(fs, fsPath)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := localTree(localPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	res := &SyncResult{}
+	if opts.Delete {
+		for _, rel := range deletions(src, dst) {
+			res.Deleted = append(res.Deleted, rel)
+			if !opts.DryRun {
+				err = os.RemoveAll(filepath.Join(localPath, filepath.FromSlash(rel)))
+				if err != nil {
+					return res, err
+				}
+			}
+		}
+	}
+	if !opts.DryRun {
+		err = os.MkdirAll(localPath, 0755)
+		if err != nil {
+			return res, err
+		}
+	}
+	for _, rel := range sortedKeys(src) {
+		fi := src[rel]
+		name := path.Join(fsPath, rel)
+		local := filepath.Join(localPath, filepath.FromSlash(rel))
+		old, exists := dst[rel]
+		if exists && old.IsDir() == fi.IsDir() {
+			if fi.IsDir() {
+				continue
+			}
+			same, err := sameFile(old, fi, local, opts.Checksum)
+			if err != nil {
+				return res, err
+			}
+			if same {
+				continue
+			}
+		}
+		if !fi.IsDir() {
+			res.Copied = append(res.Copied, rel)
+		}
+		if opts.DryRun {
+			continue
+		}
+		if exists && old.IsDir() != fi.IsDir() {
+			err = os.RemoveAll(local)
+			if err != nil {
+				return res, err
+			}
+		}
+		if fi.IsDir() {
+			err = os.Mkdir(local, 0755)
+		} else {
+			err = copyToDir(fs, name, local)
+		}
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// localTree returns every regular file and directory below root, keyed by
+// slash separated relative path.
+func localTree(root string) (map[string]os.FileInfo, error) {
+	tree := make(map[string]os.FileInfo)
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			if !fi.IsDir() {
+				return &os.PathError{Op: "sync", Path: root, Err: syscall.ENOTDIR}
+			}
+			return nil
+		}
+		if !fi.IsDir() && !fi.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		tree[filepath.ToSlash(rel)] = fi
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// fsTree returns every file and directory below root in fs, keyed by
+// relative path.
+func fsTree(fs FileSystem, root string) (map[string]os.FileInfo, error) {
+	fi, err := fs.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &os.PathError{Op: "sync", Path: root, Err: syscall.ENOTDIR}
+	}
+	tree := make(map[string]os.FileInfo)
+	base := path.Join(root)
+	err = walkTree(fs, root, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != root {
+			tree[strings.TrimPrefix(strings.TrimPrefix(name, base), "/")] = fi
+		}
+		return nil
+	})
+	return tree, err
+}
+
+func sortedKeys(tree map[string]os.FileInfo) []string {
+	keys := make([]string, 0, len(tree))
+	for key := range tree {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// deletions returns the entries of dst missing from src, leaving out those
+// inside a directory that is deleted as a whole.
+func deletions(src, dst map[string]os.FileInfo) []string {
+	var dels []string
+	for _, rel := range sortedKeys(dst) {
+		if _, ok := src[rel]; ok {
+			continue
+		}
+		if n := len(dels); n > 0 && strings.HasPrefix(rel, dels[n-1]+"/") {
+			continue
+		}
+		dels = append(dels, rel)
+	}
+	return dels
+}
+
+// sameFile compares a local file with one in boltfs, reading the local file
+// to compare hashes when checksum is set.
+func sameFile(local, stored os.FileInfo, localPath string, checksum bool) (bool, error) {
+	if local.Size() != stored.Size() {
+		return false, nil
+	}
+	if !checksum {
+		return local.ModTime().Equal(stored.ModTime()), nil
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return false, err
+	}
+	return `"`+hex.EncodeToString(h.Sum(nil))+`"` == fileETag(stored), nil
+}
+
+func mkdirAll(fs FileSystem, name string) error {
+	fi, err := fs.Stat(name)
+	if err == nil {
+		if !fi.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	dir := path.Dir(strings.TrimSuffix(name, "/"))
+	if dir != name {
+		err = mkdirAll(fs, dir)
+		if err != nil {
+			return err
+		}
+	}
+	err = fs.Mkdir(name)
+	if os.IsExist(err) {
+		return nil
+	}
+	return err
+}
+
+func copyToFS(fs FileSystem, local, name string, fi os.FileInfo) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	wc, err := fs.CreateWithOptions(name, &CreateOptions{
+		ContentType: mime.TypeByExtension(path.Ext(name)),
+		ModTime:     fi.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(wc, f)
+	if err != nil {
+		if a, ok := wc.(Aborter); ok {
+			a.Abort()
+		}
+		return err
+	}
+	return wc.Close()
+}
+
+func copyToDir(fs FileSystem, name, local string) error {
+	rd, err := NewReader(fs, name)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(local), ".boltfs-sync-")
+	if err != nil {
+		return err
+	}
+	err = tmp.Chmod(0644)
+	if err == nil {
+		_, err = io.Copy(tmp, rd)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chtimes(tmp.Name(), rd.Stat().ModTime(), rd.Stat().ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), local)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package boltfs
+
+import (
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSync(t *testing.T) {
+	Convey("When syncing with a local directory", t, func() {
+		os.Remove("sync_test.db")
+		defer os.Remove("sync_test.db")
+		db, err := bolt.Open("sync_test.db", 0644, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fs, err := NewFileSystem(NewBoltDB(db), NewBucketPath([]byte("test")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir, err := ioutil.TempDir("", "boltfs-sync")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		writeLocal := func(rel, data string) {
+			p := filepath.Join(dir, rel)
+			So(os.MkdirAll(filepath.Dir(p), 0755), ShouldBeNil)
+			So(ioutil.WriteFile(p, []byte(data), 0644), ShouldBeNil)
+		}
+		read := func(name string) string {
+			f, err := fs.Open(name)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			data, err := ioutil.ReadAll(f)
+			So(err, ShouldBeNil)
+			return string(data)
+		}
+		writeLocal("index.html", "<html>")
+		writeLocal("css/site.css", "body{}")
+
+		Convey("Should copy only changed files from a directory", func() {
+			res, err := SyncFromDir(fs, dir, "assets", nil)
+			So(err, ShouldBeNil)
+			So(res.Copied, ShouldResemble, []string{"css/site.css", "index.html"})
+			So(read("assets/css/site.css"), ShouldEqual, "body{}")
+			fi, _ := fs.Stat("assets/index.html")
+			So(fi.Sys().(*FileMeta).ContentType, ShouldStartWith, "text/html")
+
+			res, err = SyncFromDir(fs, dir, "assets", nil)
+			So(err, ShouldBeNil)
+			So(res.Copied, ShouldBeEmpty)
+
+			writeLocal("index.html", "<html>")
+			later := time.Now().Add(time.Hour)
+			os.Chtimes(filepath.Join(dir, "index.html"), later, later)
+			res, err = SyncFromDir(fs, dir, "assets", &SyncOptions{Checksum: true})
+			So(err, ShouldBeNil)
+			So(res.Copied, ShouldBeEmpty)
+			res, err = SyncFromDir(fs, dir, "assets", nil)
+			So(err, ShouldBeNil)
+			So(res.Copied, ShouldResemble, []string{"index.html"})
+		})
+		Convey("Should propagate deletes and support dry runs", func() {
+			_, err := SyncFromDir(fs, dir, "assets", nil)
+			So(err, ShouldBeNil)
+			So(os.RemoveAll(filepath.Join(dir, "css")), ShouldBeNil)
+
+			res, err := SyncFromDir(fs, dir, "assets", &SyncOptions{Delete: true, DryRun: true})
+			So(err, ShouldBeNil)
+			So(res.Deleted, ShouldResemble, []string{"css"})
+			_, err = fs.Stat("assets/css/site.css")
+			So(err, ShouldBeNil)
+
+			res, err = SyncFromDir(fs, dir, "assets", &SyncOptions{Delete: true})
+			So(err, ShouldBeNil)
+			So(res.Deleted, ShouldResemble, []string{"css"})
+			_, err = fs.Stat("assets/css")
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+		Convey("Should copy files to a directory", func() {
+			wc, _ := fs.Create("bundle/app.js")
+			io.WriteString(wc, "js")
+			wc.Close()
+			out := filepath.Join(dir, "out")
+
+			res, err := SyncToDir(fs, "bundle", out, nil)
+			So(err, ShouldBeNil)
+			So(res.Copied, ShouldResemble, []string{"app.js"})
+			data, err := ioutil.ReadFile(filepath.Join(out, "app.js"))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "js")
+
+			res, err = SyncToDir(fs, "bundle", out, nil)
+			So(err, ShouldBeNil)
+			So(res.Copied, ShouldBeEmpty)
+
+			writeLocal("out/stale.txt", "old")
+			res, err = SyncToDir(fs, "bundle", out, &SyncOptions{Delete: true})
+			So(err, ShouldBeNil)
+			So(res.Deleted, ShouldResemble, []string{"stale.txt"})
+			_, err = os.Stat(filepath.Join(out, "stale.txt"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+		Convey("Should sync with a FileSystem that cannot walk", func() {
+			m := NewMountFS()
+			So(m.Mount("/site", fs), ShouldBeNil)
+			res, err := SyncFromDir(m, dir, "site/assets", nil)
+			So(err, ShouldBeNil)
+			So(res.Copied, ShouldResemble, []string{"css/site.css", "index.html"})
+
+			res, err = SyncToDir(m, "site/assets", filepath.Join(dir, "out"), nil)
+			So(err, ShouldBeNil)
+			So(res.Copied, ShouldResemble, []string{"css/site.css", "index.html"})
+		})
+	})
+}
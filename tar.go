@@ -0,0 +1,279 @@
+package boltfs
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"gopkg.in/vmihailenco/msgpack.v2"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// PAX records used to keep boltfs metadata in tar archives. Xattrs use the
+// SCHILY.xattr prefix understood by GNU and BSD tar.
+const (
+	paxXattrPrefix     = "SCHILY.xattr."
+	paxContentType     = "BOLTFS.content_type"
+	paxContentEncoding = "BOLTFS.content_encoding"
+)
+
+const defaultImportTxSize = 64 << 20
+
+// ImportOptions control ImportTar.
+type ImportOptions struct {
+	// TxSize is roughly how many bytes of file data are written per
+	// transaction. It defaults to 64MiB.
+	TxSize int64
+
+	// SkipUnsupported skips entries boltfs cannot store, such as symlinks
+	// and devices, instead of failing.
+	SkipUnsupported bool
+}
+
+// ExportTar writes the file or directory root and everything below it to w
+// as a tar archive, with names relative to root. Content types, encodings
+// and xattrs are kept as PAX records. The archive is a consistent snapshot
+// read in a single transaction, which bolt keeps open until the export
+// completes.
+func (fs *boltFs) ExportTar(w io.Writer, root string) error {
+	tw := tar.NewWriter(w)
+	now := time.Now()
+	p, err := fs.fsPath(root)
+	if err != nil {
+		return &os.PathError{Op: "export", Path: root, Err: err}
+	}
+	err = fs.db.View(func(tx Transaction) error {
+		_, stat, err := fs.lookup(tx, root)
+		if err != nil {
+			return &os.PathError{Op: "export", Path: root, Err: err}
+		}
+		if !stat.Dir {
+			return exportFile(tx, tw, path.Base(root), stat)
+		}
+		return exportDir(tx, tw, p.BucketFrom(tx), "", now)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func exportDir(tx Transaction, tw *tar.Writer, bk Bucket, prefix string, now time.Time) error {
+	return walkStats(bk, prefix, func(name string, stat *fileStat) error {
+		if stat.Dir {
+			return tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     name + "/",
+				Mode:     0755,
+				ModTime:  now,
+			})
+		}
+		return exportFile(tx, tw, name, *stat)
+	})
+}
+
+// walkStats calls fn for every file and directory below bk in key order,
+// with names relative to bk prefixed by prefix. Directories are visited
+// before their contents.
+func walkStats(bk Bucket, prefix string, fn func(name string, stat *fileStat) error) error {
+	c := bk.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if reservedKey(k) {
+			continue
+		}
+		if v == nil {
+			dir := bk.Bucket(k)
+			stat := fileStat{Dir: true, Filename: dirName(dir, k)}
+			name := prefix + stat.Filename
+			err := fn(name, &stat)
+			if err != nil {
+				return err
+			}
+			err = walkStats(dir, name+"/", fn)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		var stat fileStat
+		err := msgpack.Unmarshal(v, &stat)
+		if err != nil {
+			return err
+		}
+		name := prefix + string(k)
+		if stat.Filename != "" {
+			name = prefix + stat.Filename
+		}
+		err = fn(name, &stat)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportFile(tx Transaction, tw *tar.Writer, name string, stat fileStat) error {
+	hdr := &tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       name,
+		Mode:       0644,
+		Size:       stat.Length,
+		ModTime:    stat.MTime,
+		Format:     tar.FormatPAX,
+		PAXRecords: make(map[string]string),
+	}
+	if stat.ContentType != "" {
+		hdr.PAXRecords[paxContentType] = stat.ContentType
+	}
+	if stat.ContentEncoding != "" {
+		hdr.PAXRecords[paxContentEncoding] = stat.ContentEncoding
+	}
+	for key, val := range readXattrs(tx, stat.Inode) {
+		hdr.PAXRecords[paxXattrPrefix+key] = string(val)
+	}
+	err := tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+	if stat.Length == 0 {
+		return nil
+	}
+	ibk := stat.Inode.BucketFrom(tx)
+	if ibk == nil {
+		return fmt.Errorf("export %s: missing inode", name)
+	}
+	_, err = io.Copy(tw, newBlockReader(ibk.Cursor(), stat.BlockSize, stat.Length))
+	return err
+}
+
+// ImportTar extracts a tar archive below root, replacing existing files.
+// Entry names are cleaned so they cannot escape root. Files are written in
+// large transactions rather than one per block, so an import that fails
+// part way leaves the entries from completed transactions in place.
+func (fs *boltFs) ImportTar(r io.Reader, root string, opts *ImportOptions) error {
+	if opts == nil {
+		opts = &ImportOptions{}
+	}
+	txSize := opts.TxSize
+	if txSize <= 0 {
+		txSize = defaultImportTxSize
+	}
+
+	tx, err := fs.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tx != nil {
+			tx.Rollback()
+		}
+	}()
+
+	tr := tar.NewReader(r)
+	var written int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Join("/", root, path.Clean("/"+hdr.Name))
+		sPath, names, err := fs.fsNames(name)
+		if err != nil {
+			return &os.PathError{Op: "import", Path: hdr.Name, Err: err}
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			_, err = mkdirs(tx, sPath, names)
+		case tar.TypeReg:
+			if name == path.Join("/", root) {
+				err = fmt.Errorf("import %s: file name is empty", hdr.Name)
+				break
+			}
+			var n int64
+			n, err = fs.importFile(tx, sPath, names, hdr, tr)
+			written += n
+		case tar.TypeXGlobalHeader:
+		default:
+			if !opts.SkipUnsupported {
+				err = fmt.Errorf("import %s: unsupported tar entry type %q", hdr.Name, hdr.Typeflag)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if written >= txSize {
+			err = tx.Commit()
+			tx = nil
+			if err != nil {
+				return err
+			}
+			tx, err = fs.db.Begin(true)
+			if err != nil {
+				return err
+			}
+			written = 0
+		}
+	}
+
+	err = tx.Commit()
+	tx = nil
+	return err
+}
+
+// importFile writes a file from a tar entry within tx, returning the number
+// of bytes written.
+func (fs *boltFs) importFile(tx Transaction, sPath BucketPath, names []string, hdr *tar.Header, r io.Reader) (int64, error) {
+	inode, err := fs.nextInodeTx(tx)
+	if err != nil {
+		return 0, err
+	}
+	bk := inode.BucketFrom(tx)
+	h := sha256.New()
+	var length int64
+	var block uint64
+	for {
+		// bolt needs values to stay valid until the transaction commits
+		buf := make([]byte, blockSize)
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			err := putBlock(bk, block, buf[:n])
+			if err != nil {
+				return length, err
+			}
+			block++
+			length += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return length, err
+		}
+	}
+
+	xattrs := make(map[string][]byte)
+	for key, val := range hdr.PAXRecords {
+		if strings.HasPrefix(key, paxXattrPrefix) && len(key) > len(paxXattrPrefix) {
+			xattrs[strings.TrimPrefix(key, paxXattrPrefix)] = []byte(val)
+		}
+	}
+	stat := fileStat{
+		Filename:        names[len(names)-1],
+		Length:          length,
+		BlockSize:       blockSize,
+		Inode:           inode,
+		MTime:           hdr.ModTime,
+		ContentType:     hdr.PAXRecords[paxContentType],
+		ContentEncoding: hdr.PAXRecords[paxContentEncoding],
+		Hash:            h.Sum(nil),
+	}
+	return length, putStat(tx, sPath, names, &stat, xattrs, fs.opts.Retention)
+}
@@ -0,0 +1,109 @@
+package boltfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTar(t *testing.T) {
+	Convey("When exporting and importing tar archives", t, func() {
+		os.Remove("tar_test.db")
+		defer os.Remove("tar_test.db")
+		db, err := bolt.Open("tar_test.db", 0644, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fs, err := NewFileSystem(NewBoltDB(db), NewBucketPath([]byte("test")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		read := func(name string) string {
+			f, err := fs.Open(name)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			data, err := ioutil.ReadAll(f)
+			So(err, ShouldBeNil)
+			return string(data)
+		}
+		mtime := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+		big := bytes.Repeat([]byte("x"), int(blockSize)*2+10)
+		wc, _ := fs.CreateWithOptions("src/a.txt", &CreateOptions{
+			ContentType: "text/plain",
+			Xattrs:      map[string][]byte{"user.color": []byte("blue")},
+			ModTime:     mtime,
+		})
+		io.WriteString(wc, "hello")
+		wc.Close()
+		wc, _ = fs.Create("src/sub/big")
+		wc.Write(big)
+		wc.Close()
+		fs.Mkdir("src/empty")
+
+		Convey("Should round trip a tree", func() {
+			var buf bytes.Buffer
+			So(fs.ExportTar(&buf, "src"), ShouldBeNil)
+			So(fs.ImportTar(&buf, "dst", &ImportOptions{TxSize: blockSize}), ShouldBeNil)
+
+			So(read("dst/a.txt"), ShouldEqual, "hello")
+			So(read("dst/sub/big"), ShouldEqual, string(big))
+			fi, err := fs.Stat("dst/empty")
+			So(err, ShouldBeNil)
+			So(fi.IsDir(), ShouldBeTrue)
+
+			fi, err = fs.Stat("dst/a.txt")
+			So(err, ShouldBeNil)
+			So(fi.ModTime().Equal(mtime), ShouldBeTrue)
+			meta := fi.Sys().(*FileMeta)
+			So(meta.ContentType, ShouldEqual, "text/plain")
+			So(string(meta.Xattrs["user.color"]), ShouldEqual, "blue")
+			src, _ := fs.Stat("src/sub/big")
+			dst, _ := fs.Stat("dst/sub/big")
+			So(dst.Sys().(*FileMeta).ETag, ShouldEqual, src.Sys().(*FileMeta).ETag)
+		})
+		Convey("Should export a single file", func() {
+			var buf bytes.Buffer
+			So(fs.ExportTar(&buf, "src/a.txt"), ShouldBeNil)
+			hdr, err := tar.NewReader(&buf).Next()
+			So(err, ShouldBeNil)
+			So(hdr.Name, ShouldEqual, "a.txt")
+		})
+		Convey("Should fail to export missing paths", func() {
+			So(os.IsNotExist(fs.ExportTar(ioutil.Discard, "missing")), ShouldBeTrue)
+		})
+		Convey("Should keep entries inside root", func() {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			tw.WriteHeader(&tar.Header{Name: "../../escape", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+			tw.Write([]byte("x"))
+			tw.Close()
+			So(fs.ImportTar(&buf, "dst", nil), ShouldBeNil)
+			So(read("dst/escape"), ShouldEqual, "x")
+			_, err := fs.Stat("escape")
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+		Convey("Should refuse unsupported entries unless told to skip them", func() {
+			archive := func() *bytes.Buffer {
+				var buf bytes.Buffer
+				tw := tar.NewWriter(&buf)
+				tw.WriteHeader(&tar.Header{Name: "link", Linkname: "a.txt", Typeflag: tar.TypeSymlink})
+				tw.WriteHeader(&tar.Header{Name: "file", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+				tw.Write([]byte("x"))
+				tw.Close()
+				return &buf
+			}
+			So(fs.ImportTar(archive(), "dst", nil), ShouldNotBeNil)
+			So(fs.ImportTar(archive(), "dst", &ImportOptions{SkipUnsupported: true}), ShouldBeNil)
+			So(read("dst/file"), ShouldEqual, "x")
+			_, err := fs.Stat("dst/link")
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
@@ -0,0 +1,351 @@
+package boltfs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gopkg.in/vmihailenco/msgpack.v2"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+const trashKey = "trash"
+
+// Every trash entry is a bucket holding its record, the stat of what was
+// removed and, for a directory, a copy of its tree.
+const (
+	trashInfoKey = "info"
+	trashStatKey = "stat"
+	trashTreeKey = "tree"
+)
+
+// ErrNoTrashEntry is returned for an unknown or purged trash id.
+var ErrNoTrashEntry = errors.New("no such trash entry")
+
+type trashRecord struct {
+	// Root is the namespace bucket the entry was removed from, so it is
+	// only visible to the FileSystem that removed it.
+	Root    BucketPath
+	Path    string
+	Deleted time.Time
+
+	// Usage is the space taken by the entry, which is charged to Root
+	// until it is purged or undeleted.
+	Usage Usage
+}
+
+// TrashEntry is a removed file or directory that can be undeleted.
+type TrashEntry struct {
+	ID      string
+	Path    string
+	Deleted time.Time
+	os.FileInfo
+}
+
+func (fs *boltFs) trashPath() BucketPath {
+	return fs.path.Join([]byte(trashKey))
+}
+
+// trash moves the file or directory at p from parent into a new trash
+// entry.
+func (fs *boltFs) trash(tx Transaction, parent Bucket, p BucketPath, names []string, stat *fileStat) error {
+	display := stat.Filename
+	if display == "" {
+		display = names[len(names)-1]
+	}
+	rec := trashRecord{
+		Root:    fs.root,
+		Path:    "/" + path.Join(path.Join(names[:len(names)-1]...), display),
+		Deleted: time.Now(),
+	}
+	key := p[len(p)-1]
+	var err error
+	if stat.Dir {
+		rec.Usage, err = fs.treeUsage(tx, p)
+	} else {
+		rec.Usage, err = entryUsage(parent, key, stat)
+	}
+	if err != nil {
+		return err
+	}
+	rec.Usage = rec.Usage.data()
+	err = chargeUsage(tx, fs.root, rec.Usage)
+	if err != nil {
+		return err
+	}
+
+	var buf [16]byte
+	_, err = rand.Read(buf[:])
+	if err != nil {
+		return err
+	}
+	entry, err := fs.trashPath().BucketFrom(tx).CreateBucket([]byte(hex.EncodeToString(buf[:])))
+	if err != nil {
+		return err
+	}
+	data, err := msgpack.Marshal(&rec)
+	if err != nil {
+		return err
+	}
+	err = entry.Put([]byte(trashInfoKey), data)
+	if err != nil {
+		return err
+	}
+	data, err = msgpack.Marshal(stat)
+	if err != nil {
+		return err
+	}
+	err = entry.Put([]byte(trashStatKey), data)
+	if err != nil {
+		return err
+	}
+
+	if stat.Dir {
+		tree, err := entry.CreateBucket([]byte(trashTreeKey))
+		if err != nil {
+			return err
+		}
+		err = copyBucket(parent.Bucket(key), tree)
+		if err != nil {
+			return err
+		}
+		return parent.DeleteBucket(key)
+	}
+	err = moveHistory(tx, parent, key, entry, []byte(trashStatKey))
+	if err != nil {
+		return err
+	}
+	return parent.Delete(key)
+}
+
+// readTrash reads a trash entry, returning false if it belongs to another
+// FileSystem.
+func (fs *boltFs) readTrash(entry Bucket) (trashRecord, fileStat, bool, error) {
+	var rec trashRecord
+	var stat fileStat
+	err := msgpack.Unmarshal(entry.Get([]byte(trashInfoKey)), &rec)
+	if err != nil {
+		return rec, stat, false, err
+	}
+	if len(rec.Root) != len(fs.root) || !isSubPath(rec.Root, fs.root) {
+		return rec, stat, false, nil
+	}
+	return rec, stat, true, msgpack.Unmarshal(entry.Get([]byte(trashStatKey)), &stat)
+}
+
+func (fs *boltFs) loadTrash(tx Transaction, op, id string) (Bucket, trashRecord, fileStat, error) {
+	entry := fs.trashPath().BucketFrom(tx).Bucket([]byte(id))
+	if entry == nil {
+		return nil, trashRecord{}, fileStat{}, fmt.Errorf("%s %s: %w", op, id, ErrNoTrashEntry)
+	}
+	rec, stat, ok, err := fs.readTrash(entry)
+	if err == nil && !ok {
+		err = fmt.Errorf("%s %s: %w", op, id, ErrNoTrashEntry)
+	}
+	return entry, rec, stat, err
+}
+
+// ListTrash returns the entries in the trash, oldest first.
+func (fs *boltFs) ListTrash() ([]TrashEntry, error) {
+	var entries []TrashEntry
+	err := fs.db.View(func(tx Transaction) error {
+		bk := fs.trashPath().BucketFrom(tx)
+		c := bk.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			rec, stat, ok, err := fs.readTrash(bk.Bucket(k))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			stat.xattrs = readXattrs(tx, stat.Inode)
+			entries = append(entries, TrashEntry{ID: string(k), Path: rec.Path, Deleted: rec.Deleted, FileInfo: stat})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Deleted.Before(entries[j].Deleted) })
+	return entries, nil
+}
+
+// Undelete moves the trash entry id back to the path it was removed from,
+// creating its parent directories if needed. It fails if something else
+// has been created at that path since.
+func (fs *boltFs) Undelete(id string) error {
+	return fs.db.Update(func(tx Transaction) error {
+		entry, rec, stat, err := fs.loadTrash(tx, "undelete", id)
+		if err != nil {
+			return err
+		}
+		p, names, err := fs.fsNames(rec.Path)
+		if err != nil {
+			return &os.PathError{Op: "undelete", Path: rec.Path, Err: err}
+		}
+		parent, err := mkdirs(tx, p[:len(p)-1], names[:len(names)-1])
+		if err != nil {
+			return err
+		}
+		key := p[len(p)-1]
+		if parent.Bucket(key) != nil || parent.Get(key) != nil {
+			return &os.PathError{Op: "undelete", Path: rec.Path, Err: os.ErrExist}
+		}
+
+		err = releaseUsage(tx, rec.Root, rec.Usage)
+		if err != nil {
+			return err
+		}
+		restored := statUsage(&stat)
+		if stat.Dir {
+			tree := entry.Bucket([]byte(trashTreeKey))
+			u, err := readUsage(tree)
+			if err != nil {
+				return err
+			}
+			restored.add(u)
+			bk, err := parent.CreateBucket(key)
+			if err != nil {
+				return err
+			}
+			err = copyBucket(tree, bk)
+			if err != nil {
+				return err
+			}
+		} else {
+			err = parent.Put(key, append([]byte{}, entry.Get([]byte(trashStatKey))...))
+			if err != nil {
+				return err
+			}
+			err = moveHistory(tx, entry, []byte(trashStatKey), parent, key)
+			if err != nil {
+				return err
+			}
+			u, err := historyUsage(parent, key)
+			if err != nil {
+				return err
+			}
+			restored.add(u)
+		}
+		err = addUsage(tx, p[:len(p)-1], restored)
+		if err != nil {
+			return err
+		}
+		return fs.trashPath().BucketFrom(tx).DeleteBucket([]byte(id))
+	})
+}
+
+// PurgeTrash permanently deletes the trash entries removed more than
+// olderThan ago, returning how many were deleted. With olderThan 0 it
+// empties the trash.
+func (fs *boltFs) PurgeTrash(olderThan time.Duration) (int, error) {
+	cutoff := time.Now().Add(-olderThan)
+	var n int
+	err := fs.db.Update(func(tx Transaction) error {
+		bk := fs.trashPath().BucketFrom(tx)
+		var ids [][]byte
+		var recs []trashRecord
+		c := bk.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			rec, _, ok, err := fs.readTrash(bk.Bucket(k))
+			if err != nil {
+				return err
+			}
+			if ok && !rec.Deleted.After(cutoff) {
+				ids = append(ids, append([]byte{}, k...))
+				recs = append(recs, rec)
+			}
+		}
+		for i, id := range ids {
+			err := purgeEntry(tx, bk.Bucket(id))
+			if err != nil {
+				return err
+			}
+			err = releaseUsage(tx, recs[i].Root, recs[i].Usage)
+			if err != nil {
+				return err
+			}
+			err = bk.DeleteBucket(id)
+			if err != nil {
+				return err
+			}
+		}
+		n = len(ids)
+		return nil
+	})
+	return n, err
+}
+
+// trashUsage returns the space charged to p and the directories below it
+// for the entries they moved to the trash.
+func (fs *boltFs) trashUsage(tx Transaction, p BucketPath) (Usage, error) {
+	var u Usage
+	bk := fs.trashPath().BucketFrom(tx)
+	c := bk.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		var rec trashRecord
+		err := msgpack.Unmarshal(bk.Bucket(k).Get([]byte(trashInfoKey)), &rec)
+		if err != nil {
+			return u, err
+		}
+		if isSubPath(p, rec.Root) && rec.Root.BucketFrom(tx) != nil {
+			u.add(rec.Usage)
+		}
+	}
+	return u, nil
+}
+
+// purgeEntry deletes the inodes of everything in a trash entry.
+func purgeEntry(tx Transaction, entry Bucket) error {
+	if tree := entry.Bucket([]byte(trashTreeKey)); tree != nil {
+		return deleteTree(tx, tree)
+	}
+	var stat fileStat
+	err := msgpack.Unmarshal(entry.Get([]byte(trashStatKey)), &stat)
+	if err != nil {
+		return err
+	}
+	deleteInode(tx, stat.Inode)
+	return deleteHistory(tx, entry, []byte(trashStatKey))
+}
+
+// walkTrash calls fn for every file and version kept in the trash, by
+// every FileSystem of the store.
+func (fs *boltFs) walkTrash(tx Transaction, fn func(stat *fileStat) error) error {
+	bk := fs.trashPath().BucketFrom(tx)
+	c := bk.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		entry := bk.Bucket(k)
+		var stat fileStat
+		err := msgpack.Unmarshal(entry.Get([]byte(trashStatKey)), &stat)
+		if err != nil {
+			return err
+		}
+		if !stat.Dir {
+			err = fn(&stat)
+			if err != nil {
+				return err
+			}
+		}
+		if tree := entry.Bucket([]byte(trashTreeKey)); tree != nil {
+			err = walkStats(tree, "", func(name string, stat *fileStat) error {
+				if stat.Dir {
+					return nil
+				}
+				return fn(stat)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		err = walkVersions(entry, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
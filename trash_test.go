@@ -0,0 +1,129 @@
+package boltfs
+
+import (
+	"errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	Convey("When removing files with a trash", t, func() {
+		opts := &Options{Trash: true, Counters: true, Retention: Retention{Versions: 1}}
+		db, fs, done := openTestFS(t, "trash", opts)
+		defer done()
+		writeFile(fs, "docs/a.txt", "old")
+		writeFile(fs, "docs/a.txt", "hello")
+		writeFile(fs, "docs/sub/b.txt", "world")
+		before, err := fs.Usage("/")
+		So(err, ShouldBeNil)
+
+		Convey("Should move removed files to the trash", func() {
+			So(fs.Remove("docs/a.txt"), ShouldBeNil)
+			_, err := fs.Stat("docs/a.txt")
+			So(os.IsNotExist(err), ShouldBeTrue)
+			entries, err := fs.ListTrash()
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0].Path, ShouldEqual, "/docs/a.txt")
+			So(entries[0].Size(), ShouldEqual, 5)
+			So(time.Since(entries[0].Deleted), ShouldBeLessThan, time.Minute)
+
+			report, err := fs.Fsck(true)
+			So(err, ShouldBeNil)
+			So(report.Orphans, ShouldEqual, 0)
+
+			So(fs.Undelete(entries[0].ID), ShouldBeNil)
+			So(readFile(fs, "docs/a.txt"), ShouldEqual, "hello")
+			versions, err := fs.Versions("docs/a.txt")
+			So(err, ShouldBeNil)
+			So(len(versions), ShouldEqual, 1)
+			after, err := fs.Usage("/")
+			So(err, ShouldBeNil)
+			So(after, ShouldResemble, before)
+
+			entries, err = fs.ListTrash()
+			So(err, ShouldBeNil)
+			So(entries, ShouldBeEmpty)
+			So(errors.Is(fs.Undelete(versions[0].ID), ErrNoTrashEntry), ShouldBeTrue)
+		})
+		Convey("Should undelete whole trees", func() {
+			So(fs.RemoveAll("docs"), ShouldBeNil)
+			u, err := fs.Usage("/")
+			So(err, ShouldBeNil)
+			So(*u, ShouldResemble, Usage{Bytes: before.Bytes, Blocks: before.Blocks})
+
+			entries, err := fs.ListTrash()
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0].IsDir(), ShouldBeTrue)
+			So(fs.Undelete(entries[0].ID), ShouldBeNil)
+			So(readFile(fs, "docs/sub/b.txt"), ShouldEqual, "world")
+			after, err := fs.Usage("/")
+			So(err, ShouldBeNil)
+			So(after, ShouldResemble, before)
+		})
+		Convey("Should not undelete over a new file", func() {
+			So(fs.RemoveAll("docs/sub"), ShouldBeNil)
+			So(fs.Remove("docs/a.txt"), ShouldBeNil)
+			So(fs.Remove("docs"), ShouldBeNil)
+			writeFile(fs, "docs/a.txt", "new")
+
+			entries, err := fs.ListTrash()
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 3)
+			So(os.IsExist(fs.Undelete(entries[1].ID)), ShouldBeTrue)
+			So(fs.Undelete(entries[0].ID), ShouldBeNil)
+			So(readFile(fs, "docs/sub/b.txt"), ShouldEqual, "world")
+		})
+		Convey("Should trash a file replaced by a rename", func() {
+			plain, err := NewFileSystemWithOptions(NewBoltDB(db), NewBucketPath([]byte("plain")), &Options{Trash: true})
+			So(err, ShouldBeNil)
+			for _, name := range []string{"a", "b"} {
+				writeFile(plain, name, name+" data")
+			}
+			So(plain.Rename("a", "b"), ShouldBeNil)
+			entries, err := plain.ListTrash()
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0].Path, ShouldEqual, "/b")
+			u, err := plain.Usage("/")
+			So(err, ShouldBeNil)
+			So(*u, ShouldResemble, Usage{Bytes: 12, Files: 1, Blocks: 2})
+
+			So(plain.Remove("b"), ShouldBeNil)
+			entries, err = plain.ListTrash()
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 2)
+			So(plain.Undelete(entries[0].ID), ShouldBeNil)
+			So(readFile(plain, "b"), ShouldEqual, "b data")
+		})
+		Convey("Should purge old entries", func() {
+			So(fs.Remove("docs/a.txt"), ShouldBeNil)
+			So(fs.RemoveAll("docs/sub"), ShouldBeNil)
+			n, err := fs.PurgeTrash(time.Hour)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+
+			sub, err := fs.Sub("docs")
+			So(err, ShouldBeNil)
+			entries, err := sub.ListTrash()
+			So(err, ShouldBeNil)
+			So(entries, ShouldBeEmpty)
+
+			n, err = fs.PurgeTrash(0)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+			entries, err = fs.ListTrash()
+			So(err, ShouldBeNil)
+			So(entries, ShouldBeEmpty)
+			u, err := fs.Usage("/")
+			So(err, ShouldBeNil)
+			So(*u, ShouldResemble, Usage{Directories: 1})
+			report, err := fs.Fsck(false)
+			So(err, ShouldBeNil)
+			So(report.Orphans, ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,230 @@
+// Package tus serves resumable uploads to a boltfs FileSystem using the
+// tus.io 1.0.0 protocol, with the creation, creation-with-upload and
+// termination extensions.
+//
+// A POST to a directory path creates an upload of the file named by the
+// "filename" metadata in that directory; a POST to any other path uploads
+// to that path. The upload is then available at BasePath followed by its
+// id. Upload state is kept in bolt, so uploads can be resumed after the
+// server restarts.
+package tus
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/mastercactapus/boltfs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	version         = "1.0.0"
+	offsetType      = "application/offset+octet-stream"
+	metaXattrPrefix = "tus.meta."
+)
+
+// Options configure a Handler.
+type Options struct {
+	// BasePath is the path the Handler is served under, used to build
+	// upload URLs. It defaults to "/".
+	BasePath string
+
+	// MaxSize limits the length of an upload, if greater than zero.
+	MaxSize int64
+}
+
+// Handler implements the tus protocol on top of a boltfs FileSystem.
+type Handler struct {
+	fs   boltfs.UploadFS
+	opts Options
+}
+
+// NewHandler returns a Handler storing uploads in fs. opts may be nil.
+func NewHandler(fs boltfs.UploadFS, opts *Options) *Handler {
+	h := &Handler{fs: fs}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if !strings.HasSuffix(h.opts.BasePath, "/") {
+		h.opts.BasePath += "/"
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hdr := w.Header()
+	hdr.Set("Tus-Resumable", version)
+	if r.Method == "OPTIONS" {
+		hdr.Set("Tus-Version", version)
+		hdr.Set("Tus-Extension", "creation,creation-with-upload,termination")
+		if h.opts.MaxSize > 0 {
+			hdr.Set("Tus-Max-Size", strconv.FormatInt(h.opts.MaxSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != version {
+		hdr.Set("Tus-Version", version)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+	if r.Method == "POST" {
+		h.create(w, r)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/")
+	switch r.Method {
+	case "HEAD":
+		info, err := h.fs.StatUpload(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		hdr.Set("Cache-Control", "no-store")
+		hdr.Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+		if info.Length >= 0 {
+			hdr.Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+		} else {
+			hdr.Set("Upload-Defer-Length", "1")
+		}
+		w.WriteHeader(http.StatusOK)
+	case "PATCH":
+		if r.Header.Get("Content-Type") != offsetType {
+			http.Error(w, "expected Content-Type "+offsetType, http.StatusUnsupportedMediaType)
+			return
+		}
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+			return
+		}
+		h.write(w, r, id, offset)
+	case "DELETE":
+		err := h.fs.RemoveUpload(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, boltfs.ErrNoUpload):
+		http.Error(w, "upload not found", http.StatusNotFound)
+	case errors.Is(err, boltfs.ErrUploadOffset):
+		http.Error(w, "upload offset mismatch", http.StatusConflict)
+	case errors.Is(err, boltfs.ErrUploadTooLarge):
+		http.Error(w, "upload exceeds its length", http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// parseMetadata decodes an Upload-Metadata header.
+func parseMetadata(s string) (map[string]string, bool) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, " ", 2)
+		var val []byte
+		if len(parts) == 2 {
+			var err error
+			val, err = base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, false
+			}
+		}
+		meta[parts[0]] = string(val)
+	}
+	return meta, true
+}
+
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "deferred length is not supported", http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if h.opts.MaxSize > 0 && length > h.opts.MaxSize {
+		http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	meta, ok := parseMetadata(r.Header.Get("Upload-Metadata"))
+	if !ok {
+		http.Error(w, "invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+
+	name := path.Clean("/" + r.URL.Path)
+	if fi, err := h.fs.Stat(name); strings.HasSuffix(r.URL.Path, "/") || (err == nil && fi.IsDir()) {
+		if meta["filename"] == "" || strings.Contains(meta["filename"], "/") {
+			http.Error(w, "expected a filename in Upload-Metadata", http.StatusBadRequest)
+			return
+		}
+		name = path.Join(name, meta["filename"])
+	}
+	opts := &boltfs.CreateOptions{
+		ContentType: meta["filetype"],
+		Xattrs:      make(map[string][]byte),
+	}
+	for key, val := range meta {
+		opts.Xattrs[metaXattrPrefix+key] = []byte(val)
+	}
+	id, err := h.fs.CreateUpload(name, length, opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", h.opts.BasePath+id)
+
+	if r.Header.Get("Content-Type") != offsetType {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	// the upload exists even if the body was cut short, so the client can
+	// resume from the returned offset
+	offset, err := h.fs.WriteUpload(id, 0, r.Body)
+	if errors.Is(err, boltfs.ErrUploadTooLarge) {
+		h.fs.RemoveUpload(id)
+		w.Header().Del("Location")
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) write(w http.ResponseWriter, r *http.Request, id string, offset int64) {
+	if r.ContentLength > 0 {
+		info, err := h.fs.StatUpload(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if info.Length >= 0 && offset+r.ContentLength > info.Length {
+			http.Error(w, "upload exceeds its length", http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+	offset, err := h.fs.WriteUpload(id, offset, r.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
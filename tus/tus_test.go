@@ -0,0 +1,131 @@
+package tus
+
+import (
+	"encoding/base64"
+	"github.com/boltdb/bolt"
+	"github.com/mastercactapus/boltfs"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	Convey("When uploading with tus", t, func() {
+		os.Remove("tus_test.db")
+		defer os.Remove("tus_test.db")
+		db, err := bolt.Open("tus_test.db", 0644, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fs, err := boltfs.NewFileSystem(boltfs.NewBoltDB(db), boltfs.NewBucketPath([]byte("test")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fs.Mkdir("dir")
+		mux := http.NewServeMux()
+		mux.Handle("/files/", http.StripPrefix("/files", NewHandler(fs, &Options{BasePath: "/files/", MaxSize: 1000})))
+		srv := httptest.NewServer(mux)
+		defer srv.Close()
+
+		do := func(method, url string, hdr map[string]string, body io.Reader) *http.Response {
+			req, err := http.NewRequest(method, url, body)
+			So(err, ShouldBeNil)
+			req.Header.Set("Tus-Resumable", "1.0.0")
+			for k, v := range hdr {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			return resp
+		}
+		meta := "filename " + base64.StdEncoding.EncodeToString([]byte("hello.txt")) +
+			",filetype " + base64.StdEncoding.EncodeToString([]byte("text/plain"))
+		create := func(length int) string {
+			resp := do("POST", srv.URL+"/files/dir", map[string]string{
+				"Upload-Length":   strconv.Itoa(length),
+				"Upload-Metadata": meta,
+			}, nil)
+			So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+			loc := resp.Header.Get("Location")
+			So(loc, ShouldStartWith, "/files/")
+			return srv.URL + loc
+		}
+		patch := func(url string, offset int, data string) *http.Response {
+			return do("PATCH", url, map[string]string{
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": strconv.Itoa(offset),
+			}, strings.NewReader(data))
+		}
+
+		Convey("Should advertise the protocol", func() {
+			req, _ := http.NewRequest("OPTIONS", srv.URL+"/files/", nil)
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(resp.Header.Get("Tus-Version"), ShouldEqual, "1.0.0")
+			So(resp.Header.Get("Tus-Extension"), ShouldContainSubstring, "creation")
+			So(resp.Header.Get("Tus-Max-Size"), ShouldEqual, "1000")
+		})
+		Convey("Should upload in several requests", func() {
+			url := create(11)
+			resp := patch(url, 0, "hello ")
+			So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+			So(resp.Header.Get("Upload-Offset"), ShouldEqual, "6")
+
+			resp = do("HEAD", url, nil, nil)
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(resp.Header.Get("Upload-Offset"), ShouldEqual, "6")
+			So(resp.Header.Get("Upload-Length"), ShouldEqual, "11")
+
+			So(patch(url, 0, "oops").StatusCode, ShouldEqual, http.StatusConflict)
+			So(patch(url, 6, "world").StatusCode, ShouldEqual, http.StatusNoContent)
+
+			f, err := fs.Open("dir/hello.txt")
+			So(err, ShouldBeNil)
+			data, _ := ioutil.ReadAll(f)
+			f.Close()
+			So(string(data), ShouldEqual, "hello world")
+			fi, _ := fs.Stat("dir/hello.txt")
+			So(fi.Sys().(*boltfs.FileMeta).ContentType, ShouldEqual, "text/plain")
+
+			So(do("HEAD", url, nil, nil).StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Should accept data with the creation request", func() {
+			resp := do("POST", srv.URL+"/files/dir/other.txt", map[string]string{
+				"Upload-Length": "5",
+				"Content-Type":  "application/offset+octet-stream",
+			}, strings.NewReader("hello"))
+			So(resp.StatusCode, ShouldEqual, http.StatusCreated)
+			So(resp.Header.Get("Upload-Offset"), ShouldEqual, "5")
+			_, err := fs.Stat("dir/other.txt")
+			So(err, ShouldBeNil)
+		})
+		Convey("Should reject oversized uploads", func() {
+			resp := do("POST", srv.URL+"/files/dir", map[string]string{
+				"Upload-Length":   "1001",
+				"Upload-Metadata": meta,
+			}, nil)
+			So(resp.StatusCode, ShouldEqual, http.StatusRequestEntityTooLarge)
+			url := create(3)
+			So(patch(url, 0, "toolong").StatusCode, ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+		Convey("Should terminate uploads", func() {
+			url := create(3)
+			So(do("DELETE", url, nil, nil).StatusCode, ShouldEqual, http.StatusNoContent)
+			So(do("HEAD", url, nil, nil).StatusCode, ShouldEqual, http.StatusNotFound)
+		})
+		Convey("Should require the protocol version", func() {
+			req, _ := http.NewRequest("HEAD", srv.URL+"/files/abc", nil)
+			resp, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(resp.StatusCode, ShouldEqual, http.StatusPreconditionFailed)
+		})
+	})
+}
@@ -0,0 +1,324 @@
+package boltfs
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gopkg.in/vmihailenco/msgpack.v2"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// uploadBuffer is how much data is committed per transaction while writing
+// to an upload.
+const uploadBuffer = 8 * blockSize
+
+var (
+	// ErrNoUpload is returned for an unknown or finished upload id.
+	ErrNoUpload = errors.New("no such upload")
+	// ErrUploadOffset is returned by WriteUpload when the offset does not
+	// match the data stored so far.
+	ErrUploadOffset = errors.New("upload offset mismatch")
+	// ErrUploadTooLarge is returned by WriteUpload when the data would
+	// exceed the length of the upload.
+	ErrUploadTooLarge = errors.New("upload exceeds its length")
+)
+
+// UploadInfo describes a resumable upload.
+type UploadInfo struct {
+	ID   string
+	Name string
+	// Offset is the number of bytes stored so far.
+	Offset int64
+	// Length is the final size of the file, or -1 for a session which
+	// ends when it is closed.
+	Length  int64
+	Created time.Time
+}
+
+// uploadRecord is stored in the uploads bucket for each upload in progress.
+// Complete blocks are written to the inode as they arrive, while the
+// partial block at the end is kept in Tail so every byte acknowledged by
+// WriteUpload survives a restart.
+type uploadRecord struct {
+	Name    string
+	Length  int64
+	Offset  int64
+	Blocks  uint64
+	Tail    []byte
+	Inode   BucketPath
+	Hash    []byte
+	Created time.Time
+
+	ContentType     string
+	ContentEncoding string
+	Xattrs          map[string][]byte
+
+	// Root is the namespace bucket the upload was created in, so it can
+	// only be used through a FileSystem with the same root.
+	Root BucketPath
+}
+
+func (fs *boltFs) uploadsPath() BucketPath {
+	return fs.path.Join([]byte(uploadsKey))
+}
+
+func (fs *boltFs) loadUpload(tx Transaction, id string) (*uploadRecord, error) {
+	data := fs.uploadsPath().BucketFrom(tx).Get([]byte(id))
+	if data == nil {
+		return nil, fmt.Errorf("upload %s: %w", id, ErrNoUpload)
+	}
+	var rec uploadRecord
+	err := msgpack.Unmarshal(data, &rec)
+	if err != nil {
+		return nil, err
+	}
+	root := rec.Root
+	if root == nil {
+		root = fs.path.Join([]byte(fsKey))
+	}
+	if len(root) != len(fs.root) || !isSubPath(root, fs.root) {
+		return nil, fmt.Errorf("upload %s: %w", id, ErrNoUpload)
+	}
+	return &rec, nil
+}
+
+func (fs *boltFs) saveUpload(tx Transaction, id string, rec *uploadRecord) error {
+	data, err := msgpack.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return fs.uploadsPath().BucketFrom(tx).Put([]byte(id), data)
+}
+
+// CreateUpload starts a resumable upload of length bytes to name, returning
+// its id. The file is published atomically once all of its data has been
+// written with WriteUpload.
+func (fs *boltFs) CreateUpload(name string, length int64, opts *CreateOptions) (string, error) {
+	if length < 0 {
+		return "", fmt.Errorf("create upload %s: invalid length %d", name, length)
+	}
+	return fs.createUpload(name, length, opts)
+}
+
+func (fs *boltFs) createUpload(name string, length int64, opts *CreateOptions) (string, error) {
+	p, names, err := fs.fsNames(name)
+	if err != nil {
+		return "", &os.PathError{Op: "create", Path: name, Err: err}
+	}
+	if strings.HasSuffix(name, "/") || fs.isRoot(p) {
+		return "", fmt.Errorf("open %s: is a directory", name)
+	}
+	err = fs.checkCreate("create", name, p, names[len(names)-1])
+	if err != nil {
+		return "", err
+	}
+	if length >= 0 {
+		err = fs.checkSpace("create", name, p, length)
+		if err != nil {
+			return "", err
+		}
+	}
+	if opts == nil {
+		opts = &CreateOptions{}
+	}
+	xattrs, err := copyXattrs(name, opts.Xattrs)
+	if err != nil {
+		return "", err
+	}
+	var buf [16]byte
+	_, err = rand.Read(buf[:])
+	if err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf[:])
+
+	inode, err := fs.nextInode()
+	if err != nil {
+		return "", err
+	}
+	rec := &uploadRecord{
+		Name:            name,
+		Length:          length,
+		Inode:           inode,
+		Created:         time.Now(),
+		ContentType:     opts.ContentType,
+		ContentEncoding: opts.ContentEncoding,
+		Xattrs:          xattrs,
+		Root:            fs.root,
+	}
+	err = fs.db.Update(func(tx Transaction) error {
+		if length == 0 {
+			return fs.finishUpload(tx, id, rec)
+		}
+		return fs.saveUpload(tx, id, rec)
+	})
+	if err != nil {
+		fs.db.Update(func(tx Transaction) error {
+			deleteInode(tx, inode)
+			return nil
+		})
+		return "", err
+	}
+	return id, nil
+}
+
+// StatUpload returns the progress of an upload.
+func (fs *boltFs) StatUpload(id string) (*UploadInfo, error) {
+	var info *UploadInfo
+	err := fs.db.View(func(tx Transaction) error {
+		rec, err := fs.loadUpload(tx, id)
+		if err != nil {
+			return err
+		}
+		info = &UploadInfo{
+			ID:      id,
+			Name:    rec.Name,
+			Offset:  rec.Offset,
+			Length:  rec.Length,
+			Created: rec.Created,
+		}
+		return nil
+	})
+	return info, err
+}
+
+// WriteUpload appends the data from r to an upload, which must currently
+// hold offset bytes. Data is committed as it is read, so on error the
+// returned offset is what was stored and the upload can be resumed from
+// there. Once the upload reaches its length the file is published and the
+// upload is removed.
+func (fs *boltFs) WriteUpload(id string, offset int64, r io.Reader) (int64, error) {
+	buf := make([]byte, uploadBuffer)
+	for {
+		n, rerr := io.ReadFull(r, buf)
+		if n == 0 {
+			if rerr == io.EOF {
+				rerr = nil
+			}
+			return offset, rerr
+		}
+		err := fs.db.Update(func(tx Transaction) error {
+			rec, err := fs.loadUpload(tx, id)
+			if err != nil {
+				return err
+			}
+			if rec.Offset != offset {
+				return fmt.Errorf("upload %s: %w: at %d not %d", id, ErrUploadOffset, rec.Offset, offset)
+			}
+			if rec.Length >= 0 && offset+int64(n) > rec.Length {
+				return fmt.Errorf("upload %s: %w", id, ErrUploadTooLarge)
+			}
+			err = appendUpload(tx, rec, buf[:n])
+			if err != nil {
+				return err
+			}
+			if rec.Offset == rec.Length {
+				return fs.finishUpload(tx, id, rec)
+			}
+			return fs.saveUpload(tx, id, rec)
+		})
+		if err != nil {
+			return offset, err
+		}
+		offset += int64(n)
+		if rerr == io.ErrUnexpectedEOF || rerr == io.EOF {
+			return offset, nil
+		}
+		if rerr != nil {
+			return offset, rerr
+		}
+	}
+}
+
+// appendUpload writes p to the inode of rec, keeping any partial block in
+// rec.Tail.
+func appendUpload(tx Transaction, rec *uploadRecord, p []byte) error {
+	h := sha256.New()
+	if len(rec.Hash) > 0 {
+		err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(rec.Hash)
+		if err != nil {
+			return err
+		}
+	}
+	h.Write(p)
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	rec.Hash = state
+
+	bk := rec.Inode.BucketFrom(tx)
+	data := append(rec.Tail, p...)
+	for int64(len(data)) >= blockSize {
+		err = putBlock(bk, rec.Blocks, data[:blockSize])
+		if err != nil {
+			return err
+		}
+		rec.Blocks++
+		data = data[blockSize:]
+	}
+	rec.Tail = append([]byte{}, data...)
+	rec.Offset += int64(len(p))
+	return nil
+}
+
+func putBlock(bk Bucket, block uint64, data []byte) error {
+	blockID := make([]byte, 8)
+	binary.LittleEndian.PutUint64(blockID, block)
+	return bk.Put(blockID, data)
+}
+
+// finishUpload publishes the file for a complete upload and removes it.
+func (fs *boltFs) finishUpload(tx Transaction, id string, rec *uploadRecord) error {
+	if len(rec.Tail) > 0 {
+		err := putBlock(rec.Inode.BucketFrom(tx), rec.Blocks, rec.Tail)
+		if err != nil {
+			return err
+		}
+	}
+	h := sha256.New()
+	if len(rec.Hash) > 0 {
+		err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(rec.Hash)
+		if err != nil {
+			return err
+		}
+	}
+	sPath, names, err := fs.fsNames(rec.Name)
+	if err != nil {
+		return err
+	}
+	stat := fileStat{
+		Filename:        names[len(names)-1],
+		Length:          rec.Offset,
+		BlockSize:       blockSize,
+		Inode:           rec.Inode,
+		MTime:           time.Now(),
+		ContentType:     rec.ContentType,
+		ContentEncoding: rec.ContentEncoding,
+		Hash:            h.Sum(nil),
+	}
+	err = putStat(tx, sPath, names, &stat, rec.Xattrs, fs.opts.Retention)
+	if err != nil {
+		return err
+	}
+	return fs.uploadsPath().BucketFrom(tx).Delete([]byte(id))
+}
+
+// RemoveUpload discards an upload and the data stored for it.
+func (fs *boltFs) RemoveUpload(id string) error {
+	return fs.db.Update(func(tx Transaction) error {
+		rec, err := fs.loadUpload(tx, id)
+		if err != nil {
+			return err
+		}
+		deleteInode(tx, rec.Inode)
+		return fs.uploadsPath().BucketFrom(tx).Delete([]byte(id))
+	})
+}
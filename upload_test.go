@@ -0,0 +1,108 @@
+package boltfs
+
+import (
+	"bytes"
+	"errors"
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestUpload(t *testing.T) {
+	Convey("When using resumable uploads", t, func() {
+		os.Remove("upload_test.db")
+		defer os.Remove("upload_test.db")
+		db, err := bolt.Open("upload_test.db", 0644, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fs, err := NewFileSystem(NewBoltDB(db), NewBucketPath([]byte("test")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		read := func(name string) string {
+			f, err := fs.Open(name)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			data, err := ioutil.ReadAll(f)
+			So(err, ShouldBeNil)
+			return string(data)
+		}
+		data := bytes.Repeat([]byte("0123456789"), 10000)
+		id, err := fs.CreateUpload("dir/big", int64(len(data)), &CreateOptions{ContentType: "text/plain"})
+		So(err, ShouldBeNil)
+
+		Convey("Should resume after a failed write", func() {
+			off, err := fs.WriteUpload(id, 0, &failingReader{data: data[:50000]})
+			So(err, ShouldNotBeNil)
+			So(off, ShouldEqual, 50000)
+
+			// a new FileSystem sees the same progress, as after a restart
+			fs, err = NewFileSystem(NewBoltDB(db), NewBucketPath([]byte("test")))
+			So(err, ShouldBeNil)
+			info, err := fs.StatUpload(id)
+			So(err, ShouldBeNil)
+			So(info.Offset, ShouldEqual, 50000)
+			So(info.Name, ShouldEqual, "dir/big")
+			_, err = fs.Stat("dir/big")
+			So(os.IsNotExist(err), ShouldBeTrue)
+
+			off, err = fs.WriteUpload(id, off, bytes.NewReader(data[off:]))
+			So(err, ShouldBeNil)
+			So(off, ShouldEqual, len(data))
+			So(read("dir/big"), ShouldEqual, string(data))
+
+			fi, err := fs.Stat("dir/big")
+			So(err, ShouldBeNil)
+			So(fi.Sys().(*FileMeta).ContentType, ShouldEqual, "text/plain")
+			_, err = fs.StatUpload(id)
+			So(errors.Is(err, ErrNoUpload), ShouldBeTrue)
+		})
+		Convey("Should match the ETag of a regular write", func() {
+			_, err := fs.WriteUpload(id, 0, bytes.NewReader(data))
+			So(err, ShouldBeNil)
+			wc, _ := fs.Create("plain")
+			wc.Write(data)
+			wc.Close()
+			a, _ := fs.Stat("dir/big")
+			b, _ := fs.Stat("plain")
+			So(a.Sys().(*FileMeta).ETag, ShouldEqual, b.Sys().(*FileMeta).ETag)
+		})
+		Convey("Should reject the wrong offset", func() {
+			_, err := fs.WriteUpload(id, 10, strings.NewReader("x"))
+			So(errors.Is(err, ErrUploadOffset), ShouldBeTrue)
+		})
+		Convey("Should reject data past the length", func() {
+			_, err := fs.WriteUpload(id, 0, bytes.NewReader(append(data, 'x')))
+			So(errors.Is(err, ErrUploadTooLarge), ShouldBeTrue)
+		})
+		Convey("Should publish empty uploads immediately", func() {
+			_, err := fs.CreateUpload("empty", 0, nil)
+			So(err, ShouldBeNil)
+			So(read("empty"), ShouldEqual, "")
+		})
+		Convey("Should remove uploads", func() {
+			So(fs.RemoveUpload(id), ShouldBeNil)
+			So(errors.Is(fs.RemoveUpload(id), ErrNoUpload), ShouldBeTrue)
+			_, err := fs.WriteUpload(id, 0, strings.NewReader("x"))
+			So(errors.Is(err, ErrNoUpload), ShouldBeTrue)
+		})
+	})
+}
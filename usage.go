@@ -0,0 +1,254 @@
+package boltfs
+
+import (
+	"gopkg.in/vmihailenco/msgpack.v2"
+	"os"
+)
+
+// usageKey holds the usage of a directory tree within its bucket, for
+// FileSystems created with Options.Counters.
+const usageKey = "\x00usage"
+
+// Usage is the space taken by a file or a directory tree. The Bytes and
+// Blocks of a directory include the kept versions of its files, and those
+// of the root of a FileSystem include what it moved to the trash and the
+// data held by its snapshots.
+type Usage struct {
+	Bytes       int64
+	Files       int64
+	Directories int64
+	Blocks      int64
+}
+
+func (u *Usage) add(d Usage) {
+	u.Bytes += d.Bytes
+	u.Files += d.Files
+	u.Directories += d.Directories
+	u.Blocks += d.Blocks
+}
+
+func (u Usage) neg() Usage {
+	return Usage{-u.Bytes, -u.Files, -u.Directories, -u.Blocks}
+}
+
+// data returns the space taken by the data of u alone, as charged for a
+// kept copy that is not a file or directory of the namespace.
+func (u Usage) data() Usage {
+	return Usage{Bytes: u.Bytes, Blocks: u.Blocks}
+}
+
+// statUsage returns the usage of a single file or directory.
+func statUsage(stat *fileStat) Usage {
+	if stat.Dir {
+		return Usage{Directories: 1}
+	}
+	u := Usage{Bytes: stat.Length, Files: 1}
+	if stat.BlockSize > 0 {
+		u.Blocks = (stat.Length + stat.BlockSize - 1) / stat.BlockSize
+	}
+	return u
+}
+
+// retainedUsage returns the space taken by a kept copy of stat, which
+// counts its data but not as a file.
+func retainedUsage(stat *fileStat) Usage {
+	return statUsage(stat).data()
+}
+
+// entryUsage returns the usage of the file or directory stat stored at key
+// of bk, along with the kept versions of a file or the counters of a
+// directory.
+func entryUsage(bk Bucket, key []byte, stat *fileStat) (Usage, error) {
+	u := statUsage(stat)
+	var d Usage
+	var err error
+	if stat.Dir {
+		d, err = readUsage(bk.Bucket(key))
+	} else {
+		d, err = historyUsage(bk, key)
+	}
+	u.add(d)
+	return u, err
+}
+
+// readUsage returns the usage counted in the directory bucket bk.
+func readUsage(bk Bucket) (Usage, error) {
+	var u Usage
+	data := bk.Get([]byte(usageKey))
+	if data == nil {
+		return u, nil
+	}
+	return u, msgpack.Unmarshal(data, &u)
+}
+
+func writeUsage(bk Bucket, u Usage) error {
+	data, err := msgpack.Marshal(&u)
+	if err != nil {
+		return err
+	}
+	return bk.Put([]byte(usageKey), data)
+}
+
+// addUsage adds d to the counters of the directory dir and of every
+// directory above it, failing with ErrQuotaExceeded if one of them would
+// grow over its quota. The namespace bucket of a FileSystem created with
+// Options.Counters holds a usage record, so counting starts there and
+// addUsage does nothing for other FileSystems.
+func addUsage(tx Transaction, dir BucketPath, d Usage) error {
+	return updateUsage(tx, dir, d, true)
+}
+
+// chargeUsage is like addUsage, but never fails. It charges data kept for
+// something that was removed, which must not be refused by a quota.
+func chargeUsage(tx Transaction, dir BucketPath, d Usage) error {
+	return updateUsage(tx, dir, d, false)
+}
+
+func updateUsage(tx Transaction, dir BucketPath, d Usage, check bool) error {
+	var bk Bucket
+	counting := false
+	for _, key := range dir {
+		if bk == nil {
+			bk = tx.Bucket(key)
+		} else {
+			bk = bk.Bucket(key)
+		}
+		if bk == nil {
+			return nil
+		}
+		if !counting && bk.Get([]byte(usageKey)) == nil {
+			continue
+		}
+		counting = true
+		u, err := readUsage(bk)
+		if err != nil {
+			return err
+		}
+		u.add(d)
+		if check {
+			err = checkQuota(bk, u, d)
+			if err != nil {
+				return err
+			}
+		}
+		err = writeUsage(bk, u)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseUsage removes the charge u from the root it was charged to,
+// unless that root has been removed since.
+func releaseUsage(tx Transaction, root BucketPath, u Usage) error {
+	if root.BucketFrom(tx) == nil {
+		return nil
+	}
+	return addUsage(tx, root, u.neg())
+}
+
+// walkUsage adds up the usage of the files and versions below bk.
+func walkUsage(bk Bucket) (Usage, error) {
+	var u Usage
+	err := walkStats(bk, "", func(name string, stat *fileStat) error {
+		u.add(statUsage(stat))
+		return nil
+	})
+	if err != nil {
+		return u, err
+	}
+	err = walkVersions(bk, func(stat *fileStat) error {
+		u.add(retainedUsage(stat))
+		return nil
+	})
+	return u, err
+}
+
+// treeUsage adds up the usage of everything below the directory p,
+// including the trash entries and snapshots charged to it.
+func (fs *boltFs) treeUsage(tx Transaction, p BucketPath) (Usage, error) {
+	u, err := walkUsage(p.BucketFrom(tx))
+	if err != nil {
+		return u, err
+	}
+	for _, charged := range []func(Transaction, BucketPath) (Usage, error){fs.trashUsage, fs.snapshotUsage} {
+		d, err := charged(tx, p)
+		if err != nil {
+			return u, err
+		}
+		u.add(d)
+	}
+	return u, nil
+}
+
+// countUsage writes the counters of the directory p and of every directory
+// below it, for a FileSystem that is given Options.Counters after it was
+// created.
+func (fs *boltFs) countUsage(tx Transaction, p BucketPath) (Usage, error) {
+	bk := p.BucketFrom(tx)
+	c := bk.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v == nil && !reservedKey(k) {
+			_, err := fs.countUsage(tx, p.Join(append([]byte{}, k...)))
+			if err != nil {
+				return Usage{}, err
+			}
+		}
+	}
+	u, err := fs.treeUsage(tx, p)
+	if err != nil {
+		return u, err
+	}
+	return u, writeUsage(bk, u)
+}
+
+// Usage returns the space taken by the file name, or by everything below
+// the directory name. Directories do not count themselves. For a
+// FileSystem created with Options.Counters it reads the counters of the
+// directory instead of walking it.
+func (fs *boltFs) Usage(name string) (*Usage, error) {
+	p, err := fs.fsPath(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "usage", Path: name, Err: err}
+	}
+	var u Usage
+	err = fs.db.View(func(tx Transaction) error {
+		_, stat, err := fs.lookup(tx, name)
+		if err != nil {
+			return &os.PathError{Op: "usage", Path: name, Err: err}
+		}
+		if !stat.Dir {
+			u = statUsage(&stat)
+			return nil
+		}
+		u, err = fs.dirUsage(tx, p)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// StatFS returns the space taken by every file of the FileSystem. A
+// FileSystem returned by Sub only counts the files below its root.
+func (fs *boltFs) StatFS() (*Usage, error) {
+	var u Usage
+	err := fs.db.View(func(tx Transaction) error {
+		var err error
+		u, err = fs.dirUsage(tx, fs.root)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (fs *boltFs) dirUsage(tx Transaction, p BucketPath) (Usage, error) {
+	if fs.opts.Counters {
+		return readUsage(p.BucketFrom(tx))
+	}
+	return fs.treeUsage(tx, p)
+}
@@ -0,0 +1,95 @@
+package boltfs
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestUsage(t *testing.T) {
+	for _, counters := range []bool{false, true} {
+		Convey("When accounting for space", t, func() {
+			db, fs, done := openTestFS(t, "usage", &Options{Counters: counters})
+			defer done()
+			write := func(name string, n int) {
+				writeFile(fs, name, strings.Repeat("x", n))
+			}
+			usage := func(name string) Usage {
+				u, err := fs.Usage(name)
+				So(err, ShouldBeNil)
+				return *u
+			}
+			write("a/b/c", int(blockSize)+1)
+			write("a/d", 10)
+			So(fs.Mkdir("e"), ShouldBeNil)
+
+			Convey("Should count files, directories and blocks", func() {
+				So(usage("/"), ShouldResemble, Usage{Bytes: blockSize + 11, Files: 2, Directories: 3, Blocks: 3})
+				So(usage("a/b"), ShouldResemble, Usage{Bytes: blockSize + 1, Files: 1, Blocks: 2})
+				So(usage("a/d"), ShouldResemble, Usage{Bytes: 10, Files: 1, Blocks: 1})
+				So(usage("e"), ShouldResemble, Usage{})
+				_, err := fs.Usage("missing")
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+			Convey("Should follow changes to the tree", func() {
+				write("a/d", 5)
+				So(fs.Rename("a/b", "e/b"), ShouldBeNil)
+				So(usage("a"), ShouldResemble, Usage{Bytes: 5, Files: 1, Blocks: 1})
+				So(usage("e"), ShouldResemble, Usage{Bytes: blockSize + 1, Files: 1, Directories: 1, Blocks: 2})
+
+				So(fs.Rename("a/d", "e/b/c"), ShouldBeNil)
+				So(usage("e"), ShouldResemble, Usage{Bytes: 5, Files: 1, Directories: 1, Blocks: 1})
+				So(fs.Remove("e/b/c"), ShouldBeNil)
+				So(fs.Remove("e/b"), ShouldBeNil)
+				So(fs.RemoveAll("a"), ShouldBeNil)
+				So(usage("/"), ShouldResemble, Usage{Directories: 1})
+			})
+			Convey("Should count uploads and imports", func() {
+				id, err := fs.CreateUpload("up/f", 3, nil)
+				So(err, ShouldBeNil)
+				_, err = fs.WriteUpload(id, 0, strings.NewReader("abc"))
+				So(err, ShouldBeNil)
+
+				var buf bytes.Buffer
+				So(fs.ExportTar(&buf, "a"), ShouldBeNil)
+				So(fs.ImportTar(&buf, "copy", nil), ShouldBeNil)
+
+				So(usage("/"), ShouldResemble, Usage{Bytes: 2*blockSize + 25, Files: 5, Directories: 6, Blocks: 7})
+				st, err := fs.StatFS()
+				So(err, ShouldBeNil)
+				So(*st, ShouldResemble, usage("/"))
+
+				sub, err := fs.Sub("copy")
+				So(err, ShouldBeNil)
+				So(sub.RemoveAll("b"), ShouldBeNil)
+				u, err := sub.Usage("/")
+				So(err, ShouldBeNil)
+				So(*u, ShouldResemble, Usage{Bytes: 10, Files: 1, Blocks: 1})
+				st, err = sub.StatFS()
+				So(err, ShouldBeNil)
+				So(*st, ShouldResemble, *u)
+			})
+			Convey("Should count an existing tree when counters are turned on", func() {
+				opts := &Options{Counters: true, Retention: Retention{Versions: 1}, Trash: true}
+				again, err := NewFileSystemWithOptions(NewBoltDB(db), NewBucketPath([]byte("test")), opts)
+				So(err, ShouldBeNil)
+				for _, name := range []string{"/", "a", "a/b", "e"} {
+					u, err := again.Usage(name)
+					So(err, ShouldBeNil)
+					So(*u, ShouldResemble, usage(name))
+				}
+				wc, err := again.Create("a/b/f")
+				So(err, ShouldBeNil)
+				So(wc.Close(), ShouldBeNil)
+				u, err := again.Usage("a")
+				So(err, ShouldBeNil)
+				So(*u, ShouldResemble, Usage{Bytes: blockSize + 11, Files: 3, Directories: 1, Blocks: 3})
+
+				_, err = NewFileSystemWithOptions(NewBoltDB(db), NewBucketPath([]byte("test")), &Options{})
+				So(err, ShouldNotBeNil)
+			})
+		})
+	}
+}
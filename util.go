@@ -1,5 +1,62 @@
 package boltfs
 
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxNameLength is the longest file name accepted, in bytes.
+const MaxNameLength = 255
+
+// MaxPathDepth is the most names a path may have.
+const MaxPathDepth = 128
+
+// splitPath cleans name and returns its elements. A ".." element may not
+// leave the root, and names must be valid UTF-8 without control
+// characters. Errors wrap os.ErrInvalid.
+func splitPath(name string) ([]string, error) {
+	var parts []string
+	for _, part := range strings.Split(name, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(parts) == 0 {
+				return nil, fmt.Errorf("path leaves the root: %w", os.ErrInvalid)
+			}
+			parts = parts[:len(parts)-1]
+			continue
+		}
+		err := checkName(part)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) > MaxPathDepth {
+		return nil, fmt.Errorf("path deeper than %d: %w", MaxPathDepth, os.ErrInvalid)
+	}
+	return parts, nil
+}
+
+func checkName(name string) error {
+	if len(name) > MaxNameLength {
+		return fmt.Errorf("name longer than %d bytes: %w", MaxNameLength, os.ErrInvalid)
+	}
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("name is not valid UTF-8: %w", os.ErrInvalid)
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("name contains control characters: %w", os.ErrInvalid)
+		}
+	}
+	return nil
+}
+
 type BucketPath [][]byte
 
 func NewBucketPath(parts ...[]byte) BucketPath {
@@ -49,6 +49,9 @@ func (b *bWatcher) Get([]byte) []byte {
 func (b *bWatcher) Put([]byte, []byte) error {
 	panic("not implemented")
 }
+func (b *bWatcher) Delete([]byte) error {
+	panic("not implemented")
+}
 
 func TestBucketPath_Join(t *testing.T) {
 	Convey("The result should be the same as a new one", t, func() {
@@ -0,0 +1,206 @@
+package boltfs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Walk calls fn for root and everything below it, like filepath.Walk, with
+// the entries of each directory in sorted order. The whole tree is read in
+// a single transaction, so fn sees a consistent snapshot but must not
+// write to the FileSystem.
+func (fs *boltFs) Walk(root string, fn filepath.WalkFunc) error {
+	err := fs.db.View(func(tx Transaction) error {
+		p, err := fs.fsPath(root)
+		if err != nil {
+			return fn(root, nil, &os.PathError{Op: "walk", Path: root, Err: err})
+		}
+		_, stat, err := fs.lookup(tx, root)
+		if err != nil {
+			return fn(root, nil, &os.PathError{Op: "walk", Path: root, Err: err})
+		}
+		if stat.Dir && stat.Filename == "" {
+			stat.Filename = "/"
+		}
+		stat.xattrs = readXattrs(tx, stat.Inode)
+		err = fn(root, stat, nil)
+		if !stat.Dir || err != nil {
+			return err
+		}
+		return walkDir(tx, p.BucketFrom(tx), root, fn)
+	})
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkDir(tx Transaction, bk Bucket, name string, fn filepath.WalkFunc) error {
+	c := bk.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if reservedKey(k) {
+			continue
+		}
+		stat, err := entryStat(tx, bk, k, v)
+		if err != nil {
+			err = fn(path.Join(name, string(k)), nil, err)
+			if err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		child := path.Join(name, stat.Filename)
+		err = fn(child, stat, nil)
+		if err == filepath.SkipDir {
+			if stat.Dir {
+				continue
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if stat.Dir {
+			err = walkDir(tx, bk.Bucket(k), child, fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walkTree walks root in fs like Walk. A FileSystem without Walk is listed
+// one directory at a time, closing each before its entries are visited.
+func walkTree(fs FileSystem, root string, fn filepath.WalkFunc) error {
+	if w, ok := fs.(WalkFS); ok {
+		return w.Walk(root, fn)
+	}
+	fi, err := fs.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkFile(fs, root, fi, fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkFile(fs FileSystem, name string, fi os.FileInfo, fn filepath.WalkFunc) error {
+	err := fn(name, fi, nil)
+	if err != nil || !fi.IsDir() {
+		return err
+	}
+	f, err := fs.Open(name)
+	var inf []os.FileInfo
+	if err == nil {
+		inf, err = f.Readdir(-1)
+		f.Close()
+	}
+	if err != nil && err != io.EOF {
+		return fn(name, fi, err)
+	}
+	sort.Slice(inf, func(i, j int) bool { return inf[i].Name() < inf[j].Name() })
+	for _, child := range inf {
+		err = walkFile(fs, path.Join(name, child.Name()), child, fn)
+		if err == filepath.SkipDir {
+			if child.IsDir() {
+				continue
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Glob returns the names of all files and directories matching pattern, as
+// path.Match does for each element of the path. Names start with "/" and
+// are sorted within each directory. Elements without wildcards are looked
+// up directly, and a wildcard element only scans the keys that share its
+// literal prefix.
+func (fs *boltFs) Glob(pattern string) ([]string, error) {
+	_, err := path.Match(pattern, "")
+	if err != nil {
+		return nil, err
+	}
+	parts, err := splitPath(pattern)
+	if err != nil {
+		return nil, &os.PathError{Op: "glob", Path: pattern, Err: err}
+	}
+	if len(parts) == 0 {
+		return nil, nil
+	}
+	var matches []string
+	err = fs.db.View(func(tx Transaction) error {
+		return fs.glob(tx, fs.root.BucketFrom(tx), "/", parts, &matches)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+func (fs *boltFs) glob(tx Transaction, bk Bucket, dir string, parts []string, matches *[]string) error {
+	if bk == nil {
+		return nil
+	}
+	elem := fs.opts.fold(parts[0])
+	match := func(k, v []byte) error {
+		stat, err := entryStat(tx, bk, k, v)
+		if err != nil {
+			return err
+		}
+		name := path.Join(dir, stat.Filename)
+		if len(parts) == 1 {
+			*matches = append(*matches, name)
+			return nil
+		}
+		if !stat.Dir {
+			return nil
+		}
+		return fs.glob(tx, bk.Bucket(k), name, parts[1:], matches)
+	}
+
+	i := strings.IndexAny(elem, `*?[\`)
+	if i == -1 {
+		key := []byte(elem)
+		if bk.Bucket(key) != nil {
+			return match(key, nil)
+		}
+		if v := bk.Get(key); v != nil {
+			return match(key, v)
+		}
+		return nil
+	}
+
+	// NFC can compose the prefix with what follows it, so the folded
+	// prefix of a pattern is not always a prefix of the keys it matches.
+	prefix := []byte(elem[:i])
+	if fs.opts.NormalizeNFC {
+		prefix = nil
+	}
+	c := bk.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if reservedKey(k) {
+			continue
+		}
+		if ok, _ := path.Match(elem, string(k)); !ok {
+			continue
+		}
+		err := match(k, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
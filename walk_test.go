@@ -0,0 +1,82 @@
+package boltfs
+
+import (
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	Convey("When walking a filesystem", t, func() {
+		os.Remove("walk_test.db")
+		defer os.Remove("walk_test.db")
+		db, err := bolt.Open("walk_test.db", 0644, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fs, err := NewFileSystem(NewBoltDB(db), NewBucketPath([]byte("test")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"a/x.txt", "a/y.md", "a/b/z.txt", "c.txt", "cc/d.txt", "e"} {
+			wc, err := fs.Create(name)
+			So(err, ShouldBeNil)
+			So(wc.Close(), ShouldBeNil)
+		}
+
+		Convey("Should visit every entry in order", func() {
+			var names []string
+			err := fs.Walk("/", func(name string, fi os.FileInfo, err error) error {
+				So(err, ShouldBeNil)
+				names = append(names, name)
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(names, ShouldResemble, []string{"/", "/a", "/a/b", "/a/b/z.txt", "/a/x.txt", "/a/y.md", "/c.txt", "/cc", "/cc/d.txt", "/e"})
+		})
+		Convey("Should skip directories and stop early", func() {
+			var names []string
+			err := fs.Walk("a", func(name string, fi os.FileInfo, err error) error {
+				names = append(names, name)
+				if name == "a/b" {
+					return filepath.SkipDir
+				}
+				if name == "a/x.txt" {
+					return filepath.SkipAll
+				}
+				return nil
+			})
+			So(err, ShouldBeNil)
+			So(names, ShouldResemble, []string{"a", "a/b", "a/x.txt"})
+		})
+		Convey("Should report a missing root", func() {
+			err := fs.Walk("missing", func(name string, fi os.FileInfo, err error) error {
+				return err
+			})
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+		Convey("Should match patterns", func() {
+			matches, err := fs.Glob("/*.txt")
+			So(err, ShouldBeNil)
+			So(matches, ShouldResemble, []string{"/c.txt"})
+			matches, err = fs.Glob("c*/*")
+			So(err, ShouldBeNil)
+			So(matches, ShouldResemble, []string{"/cc/d.txt"})
+			matches, err = fs.Glob("a/*/z.txt")
+			So(err, ShouldBeNil)
+			So(matches, ShouldResemble, []string{"/a/b/z.txt"})
+			matches, err = fs.Glob("a/[xy].*")
+			So(err, ShouldBeNil)
+			So(matches, ShouldResemble, []string{"/a/x.txt", "/a/y.md"})
+			matches, err = fs.Glob("e/*")
+			So(err, ShouldBeNil)
+			So(matches, ShouldBeEmpty)
+			_, err = fs.Glob("a/[")
+			So(err, ShouldEqual, path.ErrBadPattern)
+		})
+	})
+}
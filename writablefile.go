@@ -1,18 +1,34 @@
 package boltfs
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"gopkg.in/vmihailenco/msgpack.v2"
+	"hash"
 	"io"
+	"os"
+	"strings"
+	"syscall"
 	"time"
 )
 
 type writableFile struct {
 	txFn         func(func(tx Transaction) error) error
 	iPath, sPath BucketPath
+	names        []string
 	length       int64
 	blockSize    int64
 	wc           io.WriteCloser
+	hash         hash.Hash
+
+	contentType, contentEncoding string
+	xattrs                       map[string][]byte
+	modTime                      time.Time
+
+	// checkSpace fails if a file of the given length would exceed a quota.
+	// It is called before blocks are flushed; Close checks the final length.
+	checkSpace func(length int64) error
+	keep       Retention
 }
 
 func newWritableFile(txFn func(func(tx Transaction) error) error, blockSize int64, inodePath, statPath BucketPath) *writableFile {
@@ -21,6 +37,7 @@ func newWritableFile(txFn func(func(tx Transaction) error) error, blockSize int6
 		sPath:     statPath,
 		iPath:     inodePath,
 		blockSize: blockSize,
+		hash:      sha256.New(),
 		wc:        NewChunkedWriter(&blockWriter{txFn: txFn, path: inodePath}, int(blockSize)),
 	}
 }
@@ -29,7 +46,17 @@ func (f *writableFile) Write(p []byte) (int, error) {
 	if f.wc == nil {
 		return 0, fmt.Errorf("file is closed")
 	}
+	end := f.length + int64(len(p))
+	if f.checkSpace != nil && end/f.blockSize > f.length/f.blockSize {
+		err := f.checkSpace(end)
+		if err != nil {
+			f.wc = nil
+			f.wipeInode()
+			return 0, err
+		}
+	}
 	n, err := f.wc.Write(p)
+	f.hash.Write(p[:n])
 	f.length += int64(n)
 	return n, err
 }
@@ -40,6 +67,30 @@ func (f *writableFile) wipeInode() error {
 	})
 }
 
+// SetXattr stores an xattr with the file when it is closed.
+func (f *writableFile) SetXattr(key string, value []byte) error {
+	if f.wc == nil {
+		return fmt.Errorf("file is closed")
+	}
+	if key == "" {
+		return fmt.Errorf("setxattr: empty xattr name")
+	}
+	if f.xattrs == nil {
+		f.xattrs = make(map[string][]byte)
+	}
+	f.xattrs[key] = append([]byte{}, value...)
+	return nil
+}
+
+// Abort discards everything written so far without publishing the file.
+func (f *writableFile) Abort() error {
+	if f.wc == nil {
+		return fmt.Errorf("file is closed")
+	}
+	f.wc = nil
+	return f.wipeInode()
+}
+
 func (f *writableFile) Close() error {
 	if f.wc == nil {
 		return fmt.Errorf("file is closed")
@@ -52,28 +103,27 @@ func (f *writableFile) Close() error {
 	f.wc = nil
 
 	name := string(f.sPath[len(f.sPath)-1])
-
-	stat := fileStat{Dir: false, Length: f.length, BlockSize: f.blockSize, Inode: f.iPath, MTime: time.Now(), Filename: name}
-	data, err := msgpack.Marshal(&stat)
-	if err != nil {
-		panic(err)
+	if len(f.names) > 0 {
+		name = f.names[len(f.names)-1]
+	}
+	mtime := f.modTime
+	if mtime.IsZero() {
+		mtime = time.Now()
 	}
 
+	stat := fileStat{
+		Dir:             false,
+		Length:          f.length,
+		BlockSize:       f.blockSize,
+		Inode:           f.iPath,
+		MTime:           mtime,
+		Filename:        name,
+		ContentType:     f.contentType,
+		ContentEncoding: f.contentEncoding,
+		Hash:            f.hash.Sum(nil),
+	}
 	err = f.txFn(func(tx Transaction) error {
-		bkName := f.sPath[:len(f.sPath)-1]
-		statKey := f.sPath[len(f.sPath)-1]
-		bk, err := BucketPath(bkName).MkFrom(tx)
-		if err != nil {
-			return err
-		}
-		oldData := bk.Get(statKey)
-		var oldStat fileStat
-		err = msgpack.Unmarshal(oldData, &oldStat)
-		if err == nil {
-			// attempt to delete old inode stuff
-			oldStat.Inode.DeleteFrom(tx)
-		}
-		return bk.Put(statKey, data)
+		return putStat(tx, f.sPath, f.names, &stat, f.xattrs, f.keep)
 	})
 
 	if err != nil {
@@ -82,3 +132,49 @@ func (f *writableFile) Close() error {
 	}
 	return nil
 }
+
+// putStat publishes stat at sPath along with its xattrs, creating parent
+// directories and deleting the inode of any file it replaces. names are the
+// display names of the last elements of sPath, used for new directories.
+func putStat(tx Transaction, sPath BucketPath, names []string, stat *fileStat, xattrs map[string][]byte, keep Retention) error {
+	data, err := msgpack.Marshal(stat)
+	if err != nil {
+		return err
+	}
+	statKey := sPath[len(sPath)-1]
+	var bk Bucket
+	if len(names) > 0 {
+		bk, err = mkdirs(tx, sPath[:len(sPath)-1], names[:len(names)-1])
+		if err == nil && bk.Bucket(statKey) != nil {
+			return &os.PathError{Op: "create", Path: strings.Join(names, "/"), Err: syscall.EISDIR}
+		}
+	} else {
+		bk, err = sPath[:len(sPath)-1].MkFrom(tx)
+	}
+	if err != nil {
+		return err
+	}
+	delta := statUsage(stat)
+	var oldStat fileStat
+	err = msgpack.Unmarshal(bk.Get(statKey), &oldStat)
+	if err == nil && oldStat.Filename != "" && oldStat.Filename != stat.Filename {
+		return fmt.Errorf("create %s: %w", stat.Filename, ErrNameConflict)
+	}
+	if err == nil {
+		kept, err := keepVersion(tx, bk, statKey, &oldStat, keep)
+		if err != nil {
+			return err
+		}
+		delta.add(statUsage(&oldStat).neg())
+		delta.add(kept)
+	}
+	err = addUsage(tx, sPath[:len(sPath)-1], delta)
+	if err != nil {
+		return err
+	}
+	err = bk.Put(statKey, data)
+	if err != nil {
+		return err
+	}
+	return writeXattrs(tx, stat.Inode, xattrs)
+}
@@ -77,6 +77,9 @@ func (tx *wfTxMockBucket) DeleteBucket(key []byte) error {
 func (tx *wfTxMockBucket) CreateBucket(key []byte) (Bucket, error) {
 	panic("not implemented")
 }
+func (tx *wfTxMockBucket) Delete([]byte) error {
+	panic("not implemented")
+}
 
 func (tx *wfTxMockBucket) Cursor() Cursor {
 	panic("not implemented")
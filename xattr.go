@@ -0,0 +1,153 @@
+package boltfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrNoXattr is returned when a requested extended attribute does not exist.
+var ErrNoXattr = errors.New("no such attribute")
+
+// metaPath returns the path of the metadata bucket for an inode.
+func metaPath(inode BucketPath) BucketPath {
+	return BucketPath(inode[:len(inode)-2]).Join([]byte(metaKey), inode[len(inode)-1])
+}
+
+func readXattrs(tx Bucketer, inode BucketPath) map[string][]byte {
+	if len(inode) < 2 {
+		return nil
+	}
+	bk := metaPath(inode).BucketFrom(tx)
+	if bk == nil {
+		return nil
+	}
+	xattrs := make(map[string][]byte)
+	c := bk.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		val := make([]byte, len(v))
+		copy(val, v)
+		xattrs[string(k)] = val
+	}
+	return xattrs
+}
+
+func writeXattrs(tx Bucketer, inode BucketPath, xattrs map[string][]byte) error {
+	if len(xattrs) == 0 {
+		return nil
+	}
+	bk, err := metaPath(inode).MkFrom(tx)
+	if err != nil {
+		return err
+	}
+	for key, val := range xattrs {
+		err = bk.Put([]byte(key), val)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *boltFs) statFile(tx Transaction, op, name string) (fileStat, error) {
+	_, stat, err := fs.lookup(tx, name)
+	if err != nil {
+		return stat, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	if stat.Dir {
+		return stat, fmt.Errorf("%s %s: is a directory", op, name)
+	}
+	return stat, nil
+}
+
+// ownFile is like statFile, but first gives the file an inode of its own if
+// it shares one with a snapshot, so its xattrs can be changed.
+func (fs *boltFs) ownFile(tx Transaction, op, name string) (fileStat, error) {
+	stat, err := fs.statFile(tx, op, name)
+	if err != nil || len(stat.Inode) == 0 || !sharedInode(tx, stat.Inode) {
+		return stat, err
+	}
+	p, err := fs.fsPath(name)
+	if err != nil {
+		return stat, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	bk, _, err := fs.lookup(tx, name)
+	if err != nil {
+		return stat, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	err = fs.unshareInode(tx, bk, p[len(p)-1], &stat)
+	return stat, err
+}
+
+func (fs *boltFs) SetXattr(name, key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("setxattr %s: empty xattr name", name)
+	}
+	return fs.db.Update(func(tx Transaction) error {
+		stat, err := fs.ownFile(tx, "setxattr", name)
+		if err != nil {
+			return err
+		}
+		return writeXattrs(tx, stat.Inode, map[string][]byte{key: value})
+	})
+}
+
+func (fs *boltFs) GetXattr(name, key string) ([]byte, error) {
+	var value []byte
+	err := fs.db.View(func(tx Transaction) error {
+		stat, err := fs.statFile(tx, "getxattr", name)
+		if err != nil {
+			return err
+		}
+		bk := metaPath(stat.Inode).BucketFrom(tx)
+		if bk != nil {
+			value = bk.Get([]byte(key))
+		}
+		if value == nil {
+			return fmt.Errorf("getxattr %s %s: %w", name, key, ErrNoXattr)
+		}
+		value = append([]byte{}, value...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (fs *boltFs) ListXattr(name string) ([]string, error) {
+	var keys []string
+	err := fs.db.View(func(tx Transaction) error {
+		stat, err := fs.statFile(tx, "listxattr", name)
+		if err != nil {
+			return err
+		}
+		bk := metaPath(stat.Inode).BucketFrom(tx)
+		if bk == nil {
+			return nil
+		}
+		c := bk.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (fs *boltFs) RemoveXattr(name, key string) error {
+	return fs.db.Update(func(tx Transaction) error {
+		stat, err := fs.ownFile(tx, "removexattr", name)
+		if err != nil {
+			return err
+		}
+		bk := metaPath(stat.Inode).BucketFrom(tx)
+		if bk == nil || bk.Get([]byte(key)) == nil {
+			return fmt.Errorf("removexattr %s %s: %w", name, key, ErrNoXattr)
+		}
+		return bk.Delete([]byte(key))
+	})
+}
@@ -0,0 +1,98 @@
+package boltfs
+
+import (
+	"errors"
+	"github.com/boltdb/bolt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestXattr(t *testing.T) {
+	Convey("When using extended attributes", t, func() {
+		os.Remove("xattr_test.db")
+		defer os.Remove("xattr_test.db")
+		db, err := bolt.Open("xattr_test.db", 0644, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fs, err := NewFileSystem(NewBoltDB(db), NewBucketPath([]byte("test")))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wc, err := fs.CreateWithOptions("dir/foo", &CreateOptions{Xattrs: map[string][]byte{"team": []byte("infra")}})
+		So(err, ShouldBeNil)
+		io.WriteString(wc, "hello")
+		So(wc.Close(), ShouldBeNil)
+
+		Convey("Should store attributes given at create time", func() {
+			val, err := fs.GetXattr("dir/foo", "team")
+			So(err, ShouldBeNil)
+			So(string(val), ShouldEqual, "infra")
+		})
+		Convey("Should set, list and remove attributes", func() {
+			So(fs.SetXattr("dir/foo", "source", []byte("http://example.com")), ShouldBeNil)
+			keys, err := fs.ListXattr("dir/foo")
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"source", "team"})
+
+			So(fs.RemoveXattr("dir/foo", "team"), ShouldBeNil)
+			_, err = fs.GetXattr("dir/foo", "team")
+			So(errors.Is(err, ErrNoXattr), ShouldBeTrue)
+			So(errors.Is(fs.RemoveXattr("dir/foo", "team"), ErrNoXattr), ShouldBeTrue)
+		})
+		Convey("Should copy attributes given at create time", func() {
+			xattrs := map[string][]byte{"team": []byte("infra")}
+			wc, err := fs.CreateWithOptions("dir/bar", &CreateOptions{Xattrs: xattrs})
+			So(err, ShouldBeNil)
+			xattrs["team"][0] = 'X'
+			xattrs["extra"] = []byte("x")
+			So(wc.Close(), ShouldBeNil)
+			keys, err := fs.ListXattr("dir/bar")
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"team"})
+			val, err := fs.GetXattr("dir/bar", "team")
+			So(err, ShouldBeNil)
+			So(string(val), ShouldEqual, "infra")
+		})
+		Convey("Should store attributes set while writing", func() {
+			wc, err := fs.CreateWithOptions("dir/baz", &CreateOptions{Xattrs: map[string][]byte{"team": []byte("infra")}})
+			So(err, ShouldBeNil)
+			io.WriteString(wc, "data")
+			So(wc.(XattrSetter).SetXattr("sum", []byte("1234")), ShouldBeNil)
+			_, err = fs.Stat("dir/baz")
+			So(os.IsNotExist(err), ShouldBeTrue)
+			So(wc.Close(), ShouldBeNil)
+			keys, err := fs.ListXattr("dir/baz")
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, []string{"sum", "team"})
+			So(wc.(XattrSetter).SetXattr("late", nil), ShouldNotBeNil)
+		})
+		Convey("Should expose attributes when reading a directory", func() {
+			f, err := fs.Open("dir")
+			So(err, ShouldBeNil)
+			defer f.Close()
+			inf, err := f.Readdir(-1)
+			So(err, ShouldBeNil)
+			So(len(inf), ShouldEqual, 1)
+			meta, ok := inf[0].Sys().(*FileMeta)
+			So(ok, ShouldBeTrue)
+			So(string(meta.Xattrs["team"]), ShouldEqual, "infra")
+		})
+		Convey("Should drop attributes when a file is replaced", func() {
+			wc, err := fs.Create("dir/foo")
+			So(err, ShouldBeNil)
+			So(wc.Close(), ShouldBeNil)
+			keys, err := fs.ListXattr("dir/foo")
+			So(err, ShouldBeNil)
+			So(keys, ShouldBeEmpty)
+		})
+		Convey("Should refuse attributes on directories and missing files", func() {
+			So(fs.SetXattr("dir", "team", []byte("x")), ShouldNotBeNil)
+			So(fs.SetXattr("dir/missing", "team", []byte("x")), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,201 @@
+package boltfs
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"time"
+)
+
+// ExportZip writes the file or directory root and everything below it to w
+// as a zip archive, with names relative to root. Like ExportTar it reads a
+// single consistent snapshot. Files with a content encoding are already
+// compressed and are stored rather than deflated.
+func (fs *boltFs) ExportZip(w io.Writer, root string) error {
+	zw := zip.NewWriter(w)
+	now := time.Now()
+	p, err := fs.fsPath(root)
+	if err != nil {
+		return &os.PathError{Op: "export", Path: root, Err: err}
+	}
+	err = fs.db.View(func(tx Transaction) error {
+		_, stat, err := fs.lookup(tx, root)
+		if err != nil {
+			return &os.PathError{Op: "export", Path: root, Err: err}
+		}
+		if !stat.Dir {
+			return exportZipFile(tx, zw, path.Base(root), &stat)
+		}
+		return walkStats(p.BucketFrom(tx), "", func(name string, stat *fileStat) error {
+			if stat.Dir {
+				_, err := zw.CreateHeader(&zip.FileHeader{Name: name + "/", Modified: now})
+				return err
+			}
+			return exportZipFile(tx, zw, name, stat)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func exportZipFile(tx Transaction, zw *zip.Writer, name string, stat *fileStat) error {
+	hdr := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: stat.MTime,
+	}
+	if stat.ContentEncoding != "" {
+		hdr.Method = zip.Store
+	}
+	hdr.SetMode(0644)
+	fw, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	if stat.Length == 0 {
+		return nil
+	}
+	ibk := stat.Inode.BucketFrom(tx)
+	if ibk == nil {
+		return fmt.Errorf("export %s: missing inode", name)
+	}
+	_, err = io.Copy(fw, newBlockReader(ibk.Cursor(), stat.BlockSize, stat.Length))
+	return err
+}
+
+// OpenZip returns a read-only view of the members of the zip archive stored
+// at name, suitable for http.FileServer. Members are read through a Reader
+// on demand rather than extracted, and reads fail with ErrFileChanged if
+// the archive is replaced. Members can be seeked: stored members are read
+// directly from the archive, while deflated members are decompressed again
+// from the start when seeking backwards.
+func OpenZip(fs FileSystem, name string) (http.FileSystem, error) {
+	rd, err := NewReader(fs, name)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(rd, rd.Stat().Size())
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	files := make(map[*zip.FileHeader]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[&f.FileHeader] = f
+	}
+	return &zipFS{fs: http.FS(zr), ra: rd, files: files}, nil
+}
+
+type zipFS struct {
+	fs    http.FileSystem
+	ra    io.ReaderAt
+	files map[*zip.FileHeader]*zip.File
+}
+
+func (z *zipFS) Open(name string) (http.File, error) {
+	f, err := z.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	hdr, _ := fi.Sys().(*zip.FileHeader)
+	zf := z.files[hdr]
+	if fi.IsDir() || zf == nil {
+		return f, nil
+	}
+	if zf.Method == zip.Store {
+		off, err := zf.DataOffset()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return zipMember{f, io.NewSectionReader(z.ra, off, int64(zf.UncompressedSize64))}, nil
+	}
+	return zipMember{f, &inflateSeeker{zf: zf}}, nil
+}
+
+// zipMember serves the contents of a member of a zip archive from rs.
+type zipMember struct {
+	http.File
+	rs io.ReadSeeker
+}
+
+func (m zipMember) Read(p []byte) (int, error) {
+	return m.rs.Read(p)
+}
+
+func (m zipMember) Seek(offset int64, whence int) (int64, error) {
+	return m.rs.Seek(offset, whence)
+}
+
+func (m zipMember) Close() error {
+	if c, ok := m.rs.(io.Closer); ok {
+		c.Close()
+	}
+	return m.File.Close()
+}
+
+// inflateSeeker reads a compressed member of a zip archive. Seeking only
+// moves the position; the next Read decompresses up to it, starting over
+// if it is behind what was read so far.
+type inflateSeeker struct {
+	zf        *zip.File
+	rc        io.ReadCloser
+	pos, rpos int64
+}
+
+func (s *inflateSeeker) Read(p []byte) (int, error) {
+	if s.rc == nil || s.rpos > s.pos {
+		s.Close()
+		rc, err := s.zf.Open()
+		if err != nil {
+			return 0, err
+		}
+		s.rc, s.rpos = rc, 0
+	}
+	if s.rpos < s.pos {
+		n, err := io.CopyN(io.Discard, s.rc, s.pos-s.rpos)
+		s.rpos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.rc.Read(p)
+	s.rpos += int64(n)
+	s.pos = s.rpos
+	return n, err
+}
+
+func (s *inflateSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += int64(s.zf.UncompressedSize64)
+	default:
+		return 0, fmt.Errorf("seek: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("seek: negative position %d", offset)
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+func (s *inflateSeeker) Close() error {
+	if s.rc == nil {
+		return nil
+	}
+	err := s.rc.Close()
+	s.rc = nil
+	return err
+}
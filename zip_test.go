@@ -0,0 +1,101 @@
+package boltfs
+
+import (
+	"archive/zip"
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestZip(t *testing.T) {
+	Convey("When using zip archives", t, func() {
+		_, fs, done := openTestFS(t, "zip", nil)
+		defer done()
+		writeFile(fs, "src/a.txt", "hello")
+		writeFile(fs, "src/sub/b.txt", "world")
+
+		Convey("Should export a directory", func() {
+			var buf bytes.Buffer
+			So(fs.ExportZip(&buf, "src"), ShouldBeNil)
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			So(err, ShouldBeNil)
+			var names []string
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+			}
+			So(names, ShouldResemble, []string{"a.txt", "sub/", "sub/b.txt"})
+			rc, err := zr.File[2].Open()
+			So(err, ShouldBeNil)
+			data, _ := ioutil.ReadAll(rc)
+			So(string(data), ShouldEqual, "world")
+		})
+		Convey("Should serve a stored zip as a directory", func() {
+			var buf bytes.Buffer
+			So(fs.ExportZip(&buf, "src"), ShouldBeNil)
+			writeFile(fs, "archive.zip", buf.String())
+
+			zfs, err := OpenZip(fs, "archive.zip")
+			So(err, ShouldBeNil)
+			rec := httptest.NewRecorder()
+			http.FileServer(zfs).ServeHTTP(rec, httptest.NewRequest("GET", "/sub/b.txt", nil))
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldEqual, "world")
+
+			writeFile(fs, "archive.zip", "replaced")
+			f, err := zfs.Open("/a.txt")
+			if err == nil {
+				_, err = ioutil.ReadAll(f)
+				f.Close()
+			}
+			So(err, ShouldNotBeNil)
+		})
+		Convey("Should serve ranges of stored and deflated members", func() {
+			wc, err := fs.CreateWithOptions("src/stored", &CreateOptions{ContentEncoding: "gzip"})
+			So(err, ShouldBeNil)
+			io.WriteString(wc, "stored data")
+			So(wc.Close(), ShouldBeNil)
+			writeFile(fs, "src/deflated", "deflated data")
+			var buf bytes.Buffer
+			So(fs.ExportZip(&buf, "src"), ShouldBeNil)
+			writeFile(fs, "archive.zip", buf.String())
+
+			zfs, err := OpenZip(fs, "archive.zip")
+			So(err, ShouldBeNil)
+			get := func(name, rng string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("GET", name, nil)
+				if rng != "" {
+					req.Header.Set("Range", rng)
+				}
+				rec := httptest.NewRecorder()
+				http.FileServer(zfs).ServeHTTP(rec, req)
+				return rec
+			}
+			for _, name := range []string{"/stored", "/deflated"} {
+				rec := get(name, "")
+				So(rec.Code, ShouldEqual, http.StatusOK)
+				So(rec.Body.String(), ShouldEqual, name[1:]+" data")
+				rec = get(name, "bytes=-4")
+				So(rec.Code, ShouldEqual, http.StatusPartialContent)
+				So(rec.Body.String(), ShouldEqual, "data")
+			}
+
+			f, err := zfs.Open("/deflated")
+			So(err, ShouldBeNil)
+			defer f.Close()
+			f.Seek(9, io.SeekStart)
+			data, _ := ioutil.ReadAll(f)
+			So(string(data), ShouldEqual, "data")
+			f.Seek(0, io.SeekStart)
+			data, _ = ioutil.ReadAll(f)
+			So(string(data), ShouldEqual, "deflated data")
+		})
+		Convey("Should refuse files that are not zip archives", func() {
+			_, err := OpenZip(fs, "src/a.txt")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}